@@ -0,0 +1,48 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ManifestName is the name of the media manifest in a bundle.
+const ManifestName = "manifest.txt"
+
+// WriteBundle writes a tar archive holding the output for the entries, named
+// after name with an extension for opts.Format, and a manifest listing the
+// audio file of each entry written, one per line. It returns the number of
+// entries written.
+func (entries Entries) WriteBundle(f io.Writer, name string, opts WriteOptions) (int, error) {
+	var output, manifest bytes.Buffer
+	opts.written = func(entry Entry) {
+		fmt.Fprintln(&manifest, entry.cardAudioFile(opts))
+	}
+	count, _, err := entries.Write(&output, opts)
+	if err != nil {
+		return count, err
+	}
+	w := tar.NewWriter(f)
+	now := time.Now()
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{
+		{name + formatExtension(opts.Format), output.Bytes()},
+		{ManifestName, manifest.Bytes()},
+	} {
+		header := &tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.data)), ModTime: now}
+		if err := w.WriteHeader(header); err != nil {
+			return count, fmt.Errorf("failed to write bundle: %w", err)
+		}
+		if _, err := w.Write(file.data); err != nil {
+			return count, fmt.Errorf("failed to write bundle: %w", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return count, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return count, nil
+}
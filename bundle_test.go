@@ -0,0 +1,90 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBundle(t *testing.T) {
+	input := validEntry + strings.Replace(validEntry, "0001", "0002", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0003", 1), "{{c1::eat}}", "eat", 1)
+	dir, path := writeInput(t, input)
+	bundle := filepath.Join(dir, "deck.tar")
+	var stdout bytes.Buffer
+	if err := run([]string{"-p", "TEST", "-bundle", bundle, path}, &stdout, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "generated 2 entries.") {
+		t.Errorf("unexpected output: %q", stdout.String())
+	}
+	f, err := os.Open(bundle)
+	if err != nil {
+		t.Fatalf("failed to open bundle: %v", err)
+	}
+	defer f.Close()
+	files := make(map[string]string)
+	var names []string
+	r := tar.NewReader(f)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read bundle: %v", err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("failed to read bundle: %v", err)
+		}
+		names = append(names, header.Name)
+		files[header.Name] = string(data)
+	}
+	if got := strings.Join(names, ","); got != "deck.csv,manifest.txt" {
+		t.Errorf("unexpected files: %s", got)
+	}
+	if strings.Count(files["deck.csv"], "\n") != 2 || !strings.HasPrefix(files["deck.csv"], "TEST-0001\t") {
+		t.Errorf("unexpected csv: %q", files["deck.csv"])
+	}
+	if got, expected := files["manifest.txt"], "TEST-0001.mp3\nTEST-0002.mp3\n"; got != expected {
+		t.Errorf("got manifest %q, expected %q", got, expected)
+	}
+}
+
+func TestBundleSince(t *testing.T) {
+	entries, err := NewEntriesFromFile(strings.NewReader(validEntry + strings.Replace(validEntry, "0001", "0002", 1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var previous bytes.Buffer
+	if _, _, err := entries.Write(&previous, WriteOptions{Prefix: "TEST", Limit: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	baseline, err := NewBaselineFromFile(&previous)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := entries.WriteBundle(&buf, "deck", WriteOptions{Prefix: "TEST", Baseline: baseline}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := tar.NewReader(&buf)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read bundle: %v", err)
+		}
+		data, _ := io.ReadAll(r)
+		if header.Name == ManifestName && string(data) != "TEST-0002.mp3\n" {
+			t.Errorf("manifest lists rows left out by the baseline: %q", data)
+		}
+	}
+}
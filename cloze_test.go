@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnbalancedClozeBraces(t *testing.T) {
+	for _, input := range []string{
+		"{{c1::x}",
+		"{{c1::x}}}",
+	} {
+		err := CheckClozeBraces(input)
+		if err == nil {
+			t.Errorf("%s: this is invalid but no error returned!", input)
+			continue
+		}
+		t.Logf("%s: %v", input, err)
+		entry, errs := ParseEntryBlock(strings.Replace(validEntry, "{{c1::食べる}}", input, 1))
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), "unbalanced cloze braces") {
+			t.Errorf("%s: expected a single unbalanced braces problem, got %v", input, entry.Comments())
+		}
+	}
+	if err := CheckClozeBraces("{{c1::x}} and {{c2::y}}"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
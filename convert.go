@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"io"
+)
+
+// Options configures Convert.
+type Options struct {
+	Parse ParseOptions
+	Write WriteOptions
+}
+
+// Result summarizes a conversion.
+type Result struct {
+	Stats ParseStats
+	// Count is the number of entries written.
+	Count int
+	// Dirty is the number of dirty entries, which are not written.
+	Dirty int
+}
+
+// Convert parses the entries read from in and writes them to out.
+func Convert(in io.Reader, out io.Writer, opts Options) (Result, error) {
+	return ConvertContext(context.Background(), in, out, opts)
+}
+
+// ConvertContext is like Convert but stops early with ctx.Err() once ctx is
+// done. The context is checked periodically while parsing and once more
+// before writing.
+func ConvertContext(ctx context.Context, in io.Reader, out io.Writer, opts Options) (Result, error) {
+	entries, stats, err := parseEntries(ctx, in, opts.Parse, nil)
+	result := Result{Stats: stats}
+	if err != nil {
+		return result, err
+	}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	result.Count, result.Dirty, err = entries.Write(out, opts.Write)
+	return result, err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowReader returns one line per read, waiting before each.
+type slowReader struct {
+	lines []string
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if len(r.lines) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	n := copy(p, r.lines[0])
+	r.lines[0] = r.lines[0][n:]
+	if r.lines[0] == "" {
+		r.lines = r.lines[1:]
+	}
+	return n, nil
+}
+
+func TestConvert(t *testing.T) {
+	var buf bytes.Buffer
+	result, err := Convert(strings.NewReader(validEntry), &buf, Options{Write: WriteOptions{Prefix: "TEST"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Count != 1 || result.Stats.Lines != 8 || !strings.HasPrefix(buf.String(), "TEST-0001\t") {
+		t.Errorf("unexpected result: %+v: %q", result, buf.String())
+	}
+}
+
+func TestConvertContextCanceled(t *testing.T) {
+	lines := make([]string, 0)
+	for id := 1; id <= 100; id++ {
+		for _, line := range strings.SplitAfter(strings.Replace(validEntry, "0001", fmt.Sprintf("%04d", id), 1), "\n") {
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var buf bytes.Buffer
+	start := time.Now()
+	result, err := ConvertContext(ctx, &slowReader{lines: lines, delay: time.Millisecond}, &buf, Options{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("conversion was not canceled early: took %v", elapsed)
+	}
+	if result.Stats.Lines >= len(lines) || buf.Len() != 0 {
+		t.Errorf("conversion finished despite cancellation: %+v", result)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EntryDiff lists the cards that differ from a previously generated output
+// file, by card ID.
+type EntryDiff struct {
+	Added   []string
+	Changed []ChangedEntry
+	Removed []string
+}
+
+// ChangedEntry names the fields of a card that differ from its previous row.
+type ChangedEntry struct {
+	ID     string
+	Fields []string
+}
+
+// Empty reports whether no cards differ.
+func (d EntryDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Removed) == 0
+}
+
+// Diff compares the cards written for the entries in the default format with
+// the rows of a previous output file, as read by NewBaselineFromFile.
+func (entries Entries) Diff(previous map[string][]string, opts WriteOptions) EntryDiff {
+	rows := make(map[string][]string, len(previous))
+	for _, row := range previous {
+		if opts.GUID && len(row) > 0 {
+			row = row[1:]
+		}
+		if len(row) > 0 {
+			rows[row[0]] = row
+		}
+	}
+	diff := EntryDiff{Added: []string{}, Changed: []ChangedEntry{}, Removed: []string{}}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.ID() == 0 || entry.IsDirty() {
+			continue
+		}
+		row := entry.CSV(opts)
+		seen[row[0]] = true
+		before, ok := rows[row[0]]
+		if !ok {
+			diff.Added = append(diff.Added, row[0])
+			continue
+		}
+		current, _ := entryFromRow(row, entry.fieldLayout(), entry.cardPrefix(opts))
+		old, err := entryFromRow(before, entry.fieldLayout(), entry.cardPrefix(opts))
+		if err != nil || !current.Equal(old) {
+			diff.Changed = append(diff.Changed, ChangedEntry{row[0], changedFields(current, old)})
+		}
+	}
+	for id := range rows {
+		if !seen[id] {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+// entryFromRow rebuilds the written fields of an entry from its output row.
+func entryFromRow(row []string, layout []string, prefix string) (Entry, error) {
+	e := Entry{layout: layout}
+	if len(row) < 9 {
+		return e, fmt.Errorf("expected at least 9 columns, found %d", len(row))
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(row[0], prefix+"-"), 10, 0)
+	if err != nil {
+		return e, fmt.Errorf("invalid card ID: %q: %v", row[0], err)
+	}
+	e.id = id
+	for index, name := range DefaultLayout[:5] {
+		e.setField(name, row[index+2])
+	}
+	e.tags = strings.FieldsFunc(row[csvTagsColumn], func(r rune) bool { return r == ',' })
+	extras := row[csvTagsColumn+1:]
+	for _, name := range layout {
+		if len(extras) == 0 {
+			break
+		}
+		if containsString(DefaultLayout, name) {
+			continue
+		}
+		e.setField(name, extras[0])
+		extras = extras[1:]
+	}
+	return e, nil
+}
+
+// changedFields returns the names of the fields that differ between a and b.
+func changedFields(a, b Entry) []string {
+	names := make([]string, 0)
+	fa, fb := a.textFields(), b.textFields()
+	for index, field := range fa {
+		if index >= len(fb) || field.data != fb[index].data {
+			names = append(names, field.name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	second := strings.Replace(validEntry, "0001", "0002", 1)
+	third := strings.Replace(validEntry, "0001", "0003", 1)
+	before, err := NewEntriesFromFile(strings.NewReader(validEntry + second + third))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var previous bytes.Buffer
+	if _, _, err := before.Write(&previous, WriteOptions{Prefix: "TEST"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	source := validEntry +
+		strings.Replace(strings.Replace(second, "to eat", "to consume", 1), "verb", "verb,n5", 1) +
+		strings.Replace(validEntry, "0001", "0004", 1)
+	after, err := NewEntriesFromFile(strings.NewReader(source))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	baseline, err := NewBaselineFromFile(bytes.NewReader(previous.Bytes()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := EntryDiff{
+		Added:   []string{"TEST-0004"},
+		Changed: []ChangedEntry{{"TEST-0002", []string{"definition", "tags"}}},
+		Removed: []string{"TEST-0003"},
+	}
+	if diff := after.Diff(baseline, WriteOptions{Prefix: "TEST"}); !reflect.DeepEqual(diff, expected) {
+		t.Errorf("got %+v, expected %+v", diff, expected)
+	}
+
+	dir, input := writeInput(t, source)
+	path := filepath.Join(dir, "previous.csv")
+	if err := os.WriteFile(path, previous.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write previous file: %v", err)
+	}
+	var stdout bytes.Buffer
+	if err := run([]string{"-p", "TEST", "-diff", path, input}, &stdout, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, expected := stdout.String(), "  added:   TEST-0004\n  changed: TEST-0002 (definition, tags)\n  removed: TEST-0003\n"; got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEntryEqual(t *testing.T) {
+	a, _ := ParseEntryBlock(validEntry)
+	b, _ := ParseEntryBlock(validEntry)
+	if !a.Equal(b) {
+		t.Errorf("identical entries are not equal: %+v, %+v", a, b)
+	}
+	for _, input := range []string{
+		strings.Replace(validEntry, "verb", "verb,n2", 1),
+		strings.Replace(validEntry, "verb", "", 1),
+		strings.Replace(validEntry, "0001", "0001  comment", 1),
+		strings.Replace(validEntry, "to eat", "to consume", 1),
+	} {
+		if c, _ := ParseEntryBlock(input); a.Equal(c) {
+			t.Errorf("%q: different entries are equal", input)
+		}
+	}
+	entries, err := NewEntriesFromFile(strings.NewReader(validEntry))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := entries.MergeModifiedFromFile(strings.NewReader("0001\t2024-03-01T09:30:00Z\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.Equal(entries[0]) {
+		t.Errorf("entries with different modification times are equal")
+	}
+	phrase := strings.Replace(validEntry, "食べる\nたべる", "食べる 物\nたべる", 1)
+	c, _ := ParseEntryBlock(phrase)
+	checked, err := NewEntriesFromFileWithOptions(strings.NewReader(phrase), ParseOptions{
+		Validators: append(DefaultValidators(), PhraseValidator),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(checked[0].Warnings()) == 0 || !c.Equal(checked[0]) {
+		t.Errorf("entries differing only in warnings are not equal: %v", checked[0].Warnings())
+	}
+}
+
+func TestEntriesEach(t *testing.T) {
+	entries, err := NewEntriesFromFile(strings.NewReader(validEntry + strings.Replace(validEntry, "0001", "0042", 1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ids := make([]int64, 0)
+	entries.Each(func(entry Entry) { ids = append(ids, entry.ID()) })
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 42 {
+		t.Errorf("unexpected invocations: %v", ids)
+	}
+	count := 0
+	if allocs := testing.AllocsPerRun(10, func() { entries.Each(func(Entry) { count++ }) }); allocs != 0 {
+		t.Errorf("Each allocated %v times", allocs)
+	}
+}
+
+func TestEntriesMap(t *testing.T) {
+	entries, err := NewEntriesFromFile(strings.NewReader(validEntry + strings.Replace(validEntry, "0001", "0042", 1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := entries.Map()
+	if len(m) != 2 {
+		t.Errorf("expected 2 entries, got %d", len(m))
+	}
+	for _, id := range []int64{1, 42} {
+		if entry, ok := m[id]; !ok || !entry.Equal(entries[id-1]) {
+			t.Errorf("%04d: unexpected entry: %+v", id, entry)
+		}
+	}
+	if _, ok := m[0]; ok {
+		t.Errorf("empty slots were included")
+	}
+}
+
+func TestRenumber(t *testing.T) {
+	input := strings.Replace(validEntry, "0001", "0002", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0005", 1), "to eat", "to dine", 1) +
+		strings.Replace(validEntry, "0001", "0009", 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	renumbered := entries.Renumber()
+	for index := 0; index < 3; index++ {
+		if renumbered[index].ID() != int64(index+1) {
+			t.Errorf("entry %d has ID %d", index, renumbered[index].ID())
+		}
+	}
+	if renumbered[1].Definition() != "to dine" || renumbered[3].ID() != 0 {
+		t.Errorf("entries were reordered or duplicated")
+	}
+
+	// Only the ID lines change in the source file.
+	note := "# verbs\n"
+	_, path := writeInput(t, note+strings.Replace(input, "to dine\n", "to dine\\\nformally\n", 1))
+	var stdout bytes.Buffer
+	if err := run([]string{"-p", "TEST", "-renumber", path}, &stdout, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, line := range []string{"rename TEST-0005.mp3 to TEST-0002.mp3", "rename TEST-0009.mp3 to TEST-0003.mp3", "as new notes", "renumbered 3 entries."} {
+		if !strings.Contains(stdout.String(), line) {
+			t.Errorf("summary is missing %q: %q", line, stdout.String())
+		}
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read source file: %v", err)
+	}
+	expected := note + validEntry +
+		strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "to eat", "to dine\\\nformally", 1) +
+		strings.Replace(validEntry, "0001", "0003", 1)
+	if string(source) != expected {
+		t.Errorf("unexpected source:\n%s", source)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Kinds of fixes applied by Entry.Fix and Entries.FixSource.
+const (
+	FixWhitespace  = "whitespace"
+	FixTags        = "tags"
+	FixCloze       = "cloze numbering"
+	FixLineEndings = "line endings"
+)
+
+var unnumberedClozeRegexp = regexp.MustCompile(`{{c::`)
+
+// Fix applies safe corrections to the entry's fields and returns the kinds of
+// fixes applied. Surrounding whitespace is trimmed, blank and duplicate tags
+// are dropped and unnumbered clozes become {{c1::...}}. Tags continued over
+// several source lines are left alone. The entry must be validated again
+// afterwards.
+func (e *Entry) Fix() []string {
+	fixed := make(map[string]bool)
+	if comment := strings.TrimSpace(e.comment); comment != e.comment {
+		e.comment = comment
+		fixed[FixWhitespace] = true
+	}
+	for _, name := range e.fieldLayout() {
+		if name == "tags" {
+			continue
+		}
+		value, _ := e.Field(name)
+		data := strings.TrimSpace(value)
+		if data != value {
+			fixed[FixWhitespace] = true
+		}
+		if name == "usage" || name == "translation" {
+			if clozed := unnumberedClozeRegexp.ReplaceAllString(data, "{{c1::"); clozed != data {
+				data = clozed
+				fixed[FixCloze] = true
+			}
+		}
+		e.setField(name, data)
+	}
+	if span, ok := e.span("tags"); ok && span.first != span.last {
+		return fixKinds(fixed)
+	}
+	if tags := fixTags(e.tags); !equalStrings(tags, e.tags) {
+		e.tags = tags
+		fixed[FixTags] = true
+	}
+	return fixKinds(fixed)
+}
+
+func fixKinds(fixed map[string]bool) []string {
+	kinds := make([]string, 0, len(fixed))
+	for _, kind := range []string{FixWhitespace, FixTags, FixCloze} {
+		if fixed[kind] {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+// fixTags returns tags without blank and duplicate tags.
+func fixTags(tags []string) []string {
+	kept := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			kept = append(kept, tag)
+		}
+	}
+	return MergeTags(kept, nil)
+}
+
+// Fix applies Entry.Fix to each populated entry and validates the fixed
+// entries again using validators, or DefaultValidators when nil. It returns
+// the number of entries each kind of fix was applied to.
+func (entries *Entries) Fix(validators []Validator) map[string]int {
+	if validators == nil {
+		validators = DefaultValidators()
+	}
+	counts := make(map[string]int)
+	for index := range entries {
+		e := &entries[index]
+		if e.ID() == 0 {
+			continue
+		}
+		kinds := e.Fix()
+		if len(kinds) == 0 {
+			continue
+		}
+		for _, kind := range kinds {
+			counts[kind]++
+		}
+		e.dirty, e.flagged, e.warnings = e.marked, false, nil
+		e.comments = make([]string, 0)
+		if e.comment != "" {
+			e.comments = append(e.comments, e.comment)
+		}
+		e.validate(validators)
+	}
+	return counts
+}
+
+// FixSource makes the fixes of Entry.Fix to lines, the lines of the input file
+// named file split at each line feed, for the entries read from that file.
+// Only the lines holding a fixed value change, so comment lines and
+// continuation lines are kept. CRLF line endings anywhere in the file become
+// LF. It returns the number of entries with a CRLF line ending.
+func (entries *Entries) FixSource(file string, lines []string) int {
+	crlf := 0
+	entries.Each(func(e Entry) {
+		if e.file != file || len(e.spans) == 0 {
+			return
+		}
+		last := e.spans[len(e.spans)-1].last
+		for _, data := range lines[e.line-1 : last] {
+			if strings.HasSuffix(data, "\r") {
+				crlf++
+				break
+			}
+		}
+		e.fixSource(lines)
+	})
+	for index, data := range lines {
+		lines[index] = strings.TrimSuffix(data, "\r")
+	}
+	return crlf
+}
+
+// fixSource makes the fixes of Entry.Fix to the source lines of the entry.
+func (e Entry) fixSource(lines []string) {
+	id := strings.TrimRightFunc(lines[e.line-1], unicode.IsSpace)
+	if len(id) > dirtyOffset+1 && strings.HasPrefix(id[dirtyOffset+1:], e.idCommentPrefix()) {
+		comment := strings.TrimSpace(id[dirtyOffset+1+len(e.idCommentPrefix()):])
+		id = id[:dirtyOffset+1] + e.idCommentPrefix() + comment
+		if comment == "" {
+			id = strings.TrimRight(id[:dirtyOffset+1], " ")
+		}
+	}
+	lines[e.line-1] = id
+	for _, name := range e.fieldLayout() {
+		span, ok := e.span(name)
+		if !ok {
+			continue
+		}
+		if name == "tags" {
+			if span.first == span.last {
+				data := strings.TrimSuffix(lines[span.first-1], "\r")
+				if tags := fixTags(strings.Split(data, ",")); strings.Join(tags, ",") != data {
+					lines[span.first-1] = strings.Join(tags, ",")
+				}
+			}
+			continue
+		}
+		for n := span.first; n <= span.last; n++ {
+			data := strings.TrimSuffix(lines[n-1], "\r")
+			if n != span.last {
+				data = strings.TrimSuffix(data, EntryContinuation)
+			}
+			fixed := data
+			if n == span.first {
+				fixed = strings.TrimLeftFunc(fixed, unicode.IsSpace)
+			}
+			// Trailing whitespace after a backslash keeps it from starting
+			// a continuation line.
+			if n == span.last && !strings.HasSuffix(strings.TrimSpace(fixed), EntryContinuation) {
+				fixed = strings.TrimRightFunc(fixed, unicode.IsSpace)
+			}
+			if name == "usage" || name == "translation" {
+				fixed = unnumberedClozeRegexp.ReplaceAllString(fixed, "{{c1::")
+			}
+			if fixed == data {
+				continue
+			}
+			if n != span.last {
+				fixed += EntryContinuation
+			}
+			lines[n-1] = fixed
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFix(t *testing.T) {
+	input := strings.Replace(strings.Replace(validEntry, "to eat\n", "to eat  \r\n", 1), "verb", " verb,,verb ", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "{{c1::eat}}", "{{c::eat}}", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0003", 1), "{{c1::eat}}", "eat", 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	counts := entries.Fix(nil)
+	for kind, expected := range map[string]int{FixWhitespace: 1, FixTags: 1, FixCloze: 1} {
+		if counts[kind] != expected {
+			t.Errorf("%s: fixed %d entries, expected %d", kind, counts[kind], expected)
+		}
+	}
+	if entries[0].Definition() != "to eat" || strings.Join(entries[0].Tags(), ",") != "verb" {
+		t.Errorf("0001: not fixed: %q, %q", entries[0].Definition(), entries[0].Tags())
+	}
+	if entries[1].IsDirty() || entries[1].Translation() != "{{c1::eat}}" {
+		t.Errorf("0002: not fixed: %q, %v", entries[1].Translation(), entries[1].Comments())
+	}
+	if !entries[2].IsDirty() {
+		t.Errorf("0003: broken entry is no longer dirty")
+	}
+
+	_, path := writeInput(t, input)
+	var stdout bytes.Buffer
+	if err := run([]string{"-fix", path}, &stdout, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, line := range []string{"fixed whitespace in 1 entries.", "fixed tags in 1 entries.", "0003: [auto] translation is missing cloze deletion."} {
+		if !strings.Contains(stdout.String(), line) {
+			t.Errorf("summary is missing %q: %q", line, stdout.String())
+		}
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read source file: %v", err)
+	}
+	expected := validEntry +
+		strings.Replace(validEntry, "0001", "0002", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0003", 1), "{{c1::eat}}", "eat", 1)
+	if string(source) != expected {
+		t.Errorf("unexpected source:\n%s", source)
+	}
+}
+
+func TestFixSource(t *testing.T) {
+	input := "# header note\n" +
+		strings.Replace(validEntry, "to eat\n", "to eat \\\nto dine  \n", 1) +
+		"# TODO later\r\n" +
+		strings.Replace(strings.Replace(strings.Replace(validEntry, "0001", "0002", 1),
+			"{{c1::食べる}}\n", " {{c::食べる}}\\\nご飯を\n", 1),
+			"verb", "verb,,verb ", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0003  recheck ", 1), "verb", "verb,n5", 1)
+	_, path := writeInput(t, input)
+	var stdout bytes.Buffer
+	if err := run([]string{"-fix", "-check-tag-order", path}, &stdout, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read source file: %v", err)
+	}
+	expected := "# header note\n" +
+		strings.Replace(validEntry, "to eat\n", "to eat \\\nto dine\n", 1) +
+		"# TODO later\n" +
+		strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "{{c1::食べる}}\n", "{{c1::食べる}}\\\nご飯を\n", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0003  recheck", 1), "verb", "verb,n5", 1)
+	if string(source) != expected {
+		t.Errorf("got source:\n%s\nexpected:\n%s", source, expected)
+	}
+	for _, line := range []string{"fixed whitespace in 3 entries.", "fixed tags in 1 entries.", "fixed cloze numbering in 1 entries.", "tags are not sorted"} {
+		if !strings.Contains(stdout.String(), line) {
+			t.Errorf("output is missing %q: %q", line, stdout.String())
+		}
+	}
+	entries, err := NewEntriesFromFile(strings.NewReader(string(source)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := entries[0].Definition(); got != "to eat <br>to dine" {
+		t.Errorf("continued definition changed: %q", got)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	FormatCSV       = "csv"
+	FormatListening = "listening"
+	FormatQuizlet   = "quizlet"
+	FormatText      = "txt"
+	FormatJSONL     = "jsonl"
+	FormatBasic     = "basic"
+	FormatIndex     = "index"
+)
+
+// formatExtension returns the file name extension for output in format.
+func formatExtension(format string) string {
+	switch format {
+	case FormatText, FormatQuizlet:
+		return ".txt"
+	case FormatJSONL:
+		return ".jsonl"
+	}
+	return ".csv"
+}
+
+const (
+	SortID        = "id"
+	SortFrequency = "freq"
+)
+
+// AnkiDirectives are the "#key:value" header lines read by Anki's import.
+type AnkiDirectives struct {
+	// Separator names the column separator, such as "tab".
+	Separator string
+	// HTML tells Anki that fields hold HTML.
+	HTML bool
+	// Notetype names the note type to import into. Empty omits it.
+	Notetype string
+	// TagsColumn numbers the tags column from 1. Zero uses the tags column
+	// of the format, if it is written.
+	TagsColumn int
+}
+
+// write writes the directive lines for rows with their tags in the column
+// numbered tagsColumn from 1, before any GUID column is added.
+func (d AnkiDirectives) write(f io.Writer, opts WriteOptions, tagsColumn int) error {
+	lines := []string{"#separator:" + d.Separator, fmt.Sprintf("#html:%t", d.HTML)}
+	if d.Notetype != "" {
+		lines = append(lines, "#notetype:"+d.Notetype)
+	}
+	if opts.GUID {
+		lines = append(lines, "#guid column:1")
+		tagsColumn++
+	}
+	if d.TagsColumn > 0 {
+		tagsColumn = d.TagsColumn
+	} else if opts.NoTagColumn {
+		tagsColumn = 0
+	}
+	if tagsColumn > 0 {
+		lines = append(lines, fmt.Sprintf("#tags column:%d", tagsColumn))
+	}
+	newline := "\n"
+	if opts.CRLF {
+		newline = "\r\n"
+	}
+	if _, err := io.WriteString(f, strings.Join(lines, newline)+newline); err != nil {
+		return fmt.Errorf("failed to write directives: %w", err)
+	}
+	return nil
+}
+
+// JSONEntry is the object written for each entry by FormatJSONL. Fields in
+// the layout without a dedicated member are kept in Fields.
+type JSONEntry struct {
+	ID            string            `json:"id"`
+	Input         string            `json:"input"`
+	Usage         string            `json:"usage"`
+	Translation   string            `json:"translation"`
+	Word          string            `json:"word"`
+	Pronunciation string            `json:"pronunciation"`
+	Definition    string            `json:"definition"`
+	Audio         string            `json:"audio"`
+	Tags          []string          `json:"tags"`
+	Fields        map[string]string `json:"fields,omitempty"`
+}
+
+// JSON returns the JSON object for the entry.
+func (e Entry) JSON(opts WriteOptions) JSONEntry {
+	j := JSONEntry{
+		ID:            e.cardID(opts),
+		Input:         e.escaped("usage", e.Input()),
+		Usage:         e.escaped("usage", e.Usage()),
+		Translation:   e.escaped("translation", e.Translation()),
+		Word:          e.escaped("word", e.Word()),
+		Pronunciation: e.escaped("pronunciation", e.Pronunciation()),
+		Definition:    e.escaped("definition", e.Definition()),
+		Audio:         e.cardAudio(opts),
+		Tags:          strings.FieldsFunc(e.outputTags(opts), func(r rune) bool { return r == ',' }),
+	}
+	for _, name := range e.extraFields() {
+		if j.Fields == nil {
+			j.Fields = make(map[string]string)
+		}
+		j.Fields[name] = e.escaped(name, e.fields[name])
+	}
+	return j
+}
+
+// ListeningCSV returns the output row for a listening card, which only has
+// the usage and its audio.
+func (e Entry) ListeningCSV(opts WriteOptions) []string {
+	row := []string{
+		e.cardID(opts),
+		e.escaped("usage", e.Usage()),
+		e.cardAudio(opts),
+		e.outputTags(opts),
+	}
+	if opts.NoTagColumn {
+		row = row[:3]
+	}
+	return row
+}
+
+// BasicCSV returns the output row for Anki's basic note type, with the word
+// on the front and the definition on the back.
+func (e Entry) BasicCSV(opts WriteOptions) []string {
+	row := []string{
+		e.cardID(opts),
+		e.escaped("word", e.Word()),
+		e.escaped("definition", e.Definition()),
+		e.outputTags(opts),
+	}
+	if opts.NoTagColumn {
+		row = row[:3]
+	}
+	return row
+}
+
+// HTML returns the usage as shown on the front of its cards, with each cloze
+// replaced by "[...]", or by "[hint]" if the cloze has a hint.
+func (e Entry) HTML() string {
+	return clozeRegexp.ReplaceAllStringFunc(e.Usage(), func(cloze string) string {
+		parts := strings.SplitN(clozeRegexp.FindStringSubmatch(cloze)[2], "::", 2)
+		if len(parts) == 2 {
+			return "[" + parts[1] + "]"
+		}
+		return "[...]"
+	})
+}
+
+// ClozeVariant is a copy of an entry keeping the clozes of a single number.
+type ClozeVariant struct {
+	// Cloze names the cloze number kept, such as "c2".
+	Cloze string
+	Entry Entry
+}
+
+// ClozeVariants returns a variant of the entry for each distinct cloze number
+// in its usage. In each, the clozes of that number become c1 and the other
+// clozes are replaced by their answers, in both the usage and translation.
+func (e Entry) ClozeVariants() []ClozeVariant {
+	numbers := clozeNumbers(e.Usage())
+	variants := make([]ClozeVariant, 0, len(numbers))
+	for _, number := range numbers {
+		variant := e
+		variant.setField("usage", isolateCloze(e.Usage(), number))
+		variant.translation = isolateCloze(e.Translation(), number)
+		variants = append(variants, ClozeVariant{number, variant})
+	}
+	return variants
+}
+
+// isolateCloze renumbers the clozes named by number in s as c1 and replaces
+// the others with their answers.
+func isolateCloze(s, number string) string {
+	return clozeRegexp.ReplaceAllStringFunc(s, func(cloze string) string {
+		match := clozeRegexp.FindStringSubmatch(cloze)
+		if "c"+match[1] == number {
+			return "{{c1::" + match[2] + "}}"
+		}
+		return strings.SplitN(match[2], "::", 2)[0]
+	})
+}
+
+func (entries Entries) writeQuizlet(f io.Writer, opts WriteOptions) (int, int, error) {
+	term, definition, separator := opts.QuizletTerm, opts.QuizletDefinition, opts.QuizletRowSeparator
+	if term == "" {
+		term = "word"
+	}
+	if definition == "" {
+		definition = "definition"
+	}
+	if separator == "" {
+		separator = "\n"
+	}
+	return entries.writeEach(opts, func(entry Entry) (bool, error) {
+		t, ok := entry.Field(term)
+		if !ok {
+			return false, fmt.Errorf("unknown field: %q", term)
+		}
+		d, ok := entry.Field(definition)
+		if !ok {
+			return false, fmt.Errorf("unknown field: %q", definition)
+		}
+		if _, err := fmt.Fprintf(f, "%s\t%s%s", plainText(t), plainText(d), separator); err != nil {
+			return false, fmt.Errorf("failed to write quizlet data: %w", err)
+		}
+		return true, nil
+	})
+}
+
+var lineBreakRegexp = regexp.MustCompile(`(?i)<br\s*/?>`)
+
+// plainText strips markup from s and flattens it onto a single line.
+func plainText(s string) string {
+	return strings.Join(strings.Fields(StripHTML(lineBreakRegexp.ReplaceAllString(s, " "))), " ")
+}
+
+// DefinitionIndex returns the IDs of the populated entries, in ascending
+// order, keyed by their definition as plain text.
+func (entries *Entries) DefinitionIndex() map[string][]int64 {
+	index := make(map[string][]int64)
+	entries.Each(func(e Entry) {
+		if definition := plainText(e.Definition()); definition != "" {
+			index[definition] = append(index[definition], e.ID())
+		}
+	})
+	return index
+}
+
+// writeIndex writes a "definition<TAB>id,id" row for each definition of the
+// entries that would be written, sorted by definition.
+func (entries Entries) writeIndex(f io.Writer, opts WriteOptions) (int, int, error) {
+	index := make(map[string][]string)
+	count, dirty, err := entries.writeEach(opts, func(entry Entry) (bool, error) {
+		definition := plainText(entry.Definition())
+		if definition == "" {
+			return false, nil
+		}
+		index[definition] = append(index[definition], entry.cardID(opts))
+		return true, nil
+	})
+	if err != nil {
+		return count, dirty, err
+	}
+	definitions := make([]string, 0, len(index))
+	for definition := range index {
+		definitions = append(definitions, definition)
+	}
+	sort.Strings(definitions)
+	w := csv.NewWriter(f)
+	w.Comma = '\t'
+	w.UseCRLF = opts.CRLF
+	for _, definition := range definitions {
+		if err := w.Write([]string{definition, strings.Join(index[definition], ",")}); err != nil {
+			return count, dirty, fmt.Errorf("failed to write index data: %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return count, dirty, fmt.Errorf("failed to flush data: %w", err)
+	}
+	return count, dirty, nil
+}
+
+func (entries Entries) writeText(f io.Writer, opts WriteOptions) (int, int, error) {
+	return entries.writeEach(opts, func(entry Entry) (bool, error) {
+		word := plainText(entry.Word())
+		if pronunciation := plainText(entry.Pronunciation()); pronunciation != "" {
+			word = fmt.Sprintf("%s (%s)", word, pronunciation)
+		}
+		if _, err := fmt.Fprintf(f, "%s: %s\n", word, plainText(entry.Definition())); err != nil {
+			return false, fmt.Errorf("failed to write text data: %w", err)
+		}
+		return true, nil
+	})
+}
+
+func (entries Entries) writeJSONL(f io.Writer, opts WriteOptions) (int, int, error) {
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	return entries.writeEach(opts, func(entry Entry) (bool, error) {
+		if err := enc.Encode(entry.JSON(opts)); err != nil {
+			return false, fmt.Errorf("failed to write json data: %w", err)
+		}
+		return true, nil
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestQuizlet(t *testing.T) {
+	input := validEntry + strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "to eat", "to <b>eat</b> &amp; drink", 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, _, err := entries.Write(&buf, WriteOptions{Format: FormatQuizlet}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, expected := buf.String(), "食べる\tto eat\n食べる\tto eat & drink\n"; got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+	buf.Reset()
+	if _, _, err := entries.Write(&buf, WriteOptions{
+		Format:              FormatQuizlet,
+		QuizletTerm:         "pronunciation",
+		QuizletDefinition:   "word",
+		QuizletRowSeparator: ";;",
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, expected := buf.String(), "たべる\t食べる;;たべる\t食べる;;"; got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestListening(t *testing.T) {
+	entries, err := NewEntriesFromFile(strings.NewReader(validEntry))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, _, err := entries.Write(&buf, WriteOptions{Prefix: "TEST", Format: FormatListening}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, expected := buf.String(), "TEST-0001\t{{c1::食べる}}\t[sound:TEST-0001.mp3]\tverb\n"; got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestBasic(t *testing.T) {
+	input := strings.Replace(strings.Replace(validEntry, "{{c1::食べる}}", "食べる", 1), "{{c1::eat}}", "eat", 1)
+	entries, err := NewEntriesFromFileWithOptions(strings.NewReader(input), ParseOptions{Validators: BasicValidators()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entries[0].IsDirty() {
+		t.Errorf("entry without clozes is dirty: %v", entries[0].Comments())
+	}
+	var buf bytes.Buffer
+	if _, _, err := entries.Write(&buf, WriteOptions{Prefix: "TEST", Format: FormatBasic}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, expected := buf.String(), "TEST-0001\t食べる\tto eat\tverb\n"; got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestText(t *testing.T) {
+	expected, err := os.ReadFile("testdata/study.txt")
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	f, err := os.Open("testdata/entries-study.txt")
+	if err != nil {
+		t.Fatalf("failed to open input file: %v", err)
+	}
+	defer f.Close()
+	entries, err := NewEntriesFromFile(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, _, err := entries.Write(&buf, WriteOptions{Format: FormatText}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("output differs from golden file:\n%s", buf.String())
+	}
+}
+
+func TestJSONL(t *testing.T) {
+	input := validEntry + strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "to eat", "to <b>eat</b>", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0003", 1), "{{c1::eat}}", "eat", 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	count, dirty, err := entries.Write(&buf, WriteOptions{Prefix: "TEST", Format: FormatJSONL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 2 || dirty != 1 {
+		t.Errorf("got %d entries (dirty %d), expected 2 (dirty 1)", count, dirty)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected one object per line, got:\n%s", buf.String())
+	}
+	for index, line := range lines {
+		var entry JSONEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Errorf("line %d: %v: %s", index+1, err, line)
+			continue
+		}
+		if expected := fmt.Sprintf("TEST-%04d", index+1); entry.ID != expected || len(entry.Tags) != 1 {
+			t.Errorf("line %d: unexpected object: %s", index+1, line)
+		}
+	}
+	if !strings.Contains(lines[1], `"definition":"to <b>eat</b>"`) {
+		t.Errorf("markup was escaped: %s", lines[1])
+	}
+}
+
+func TestDefinitionIndex(t *testing.T) {
+	input := validEntry +
+		strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "to eat", "to <b>eat</b>", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0003", 1), "to eat", "to drink", 1) +
+		strings.Replace(strings.Replace(strings.Replace(validEntry, "0001", "0004", 1), "to eat", "to drink", 1), "{{c1::eat}}", "eat", 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	index := entries.DefinitionIndex()
+	if got := fmt.Sprint(index); got != "map[to drink:[3 4] to eat:[1 2]]" {
+		t.Errorf("unexpected index: %s", got)
+	}
+	var buf bytes.Buffer
+	count, _, err := entries.Write(&buf, WriteOptions{Prefix: "TEST", Format: FormatIndex})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, expected := buf.String(), "to drink\tTEST-0003\nto eat\tTEST-0001,TEST-0002\n"; count != 3 || got != expected {
+		t.Errorf("got %d entries in %q, expected 3 in %q", count, got, expected)
+	}
+}
+
+func TestEntryHTML(t *testing.T) {
+	for usage, expected := range map[string]string{
+		"{{c1::食べる}}":                     "[...]",
+		"<b>{{c1::ご飯}}</b>を{{c2::食べる}}":   "<b>[...]</b>を[...]",
+		"{{c1::ご飯::food}}を{{c2::食べる}}ました": "[food]を[...]ました",
+		"ご飯を食べる":                          "ご飯を食べる",
+	} {
+		entry, _ := ParseEntryBlock(strings.Replace(validEntry, "{{c1::食べる}}", usage, 1))
+		if got := entry.HTML(); got != expected {
+			t.Errorf("%s: got %q, expected %q", usage, got, expected)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitContent opens the input files at a revision for -git-since. Tests
+// replace it to avoid depending on a repository.
+var gitContent = GitShow
+
+// GitShow returns a function opening the content of a file at the git
+// revision rev, as printed by "git show rev:path" in the file's directory. A
+// file missing at rev reads as empty, so all of its entries count as new.
+func GitShow(rev string) func(path string) (io.ReadCloser, error) {
+	return func(path string) (io.ReadCloser, error) {
+		if rev == "" || strings.HasPrefix(rev, "-") {
+			return nil, fmt.Errorf("invalid git revision: %q", rev)
+		}
+		git := func(args ...string) ([]byte, string, error) {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = filepath.Dir(path)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			out, err := cmd.Output()
+			return out, strings.TrimSpace(stderr.String()), err
+		}
+		object := rev + ":./" + filepath.Base(path)
+		if _, _, err := git("cat-file", "-e", object); err != nil {
+			if _, _, err := git("rev-parse", "--verify", "--quiet", rev+"^{commit}"); err == nil {
+				return io.NopCloser(strings.NewReader("")), nil
+			}
+		}
+		out, stderr, err := git("show", object)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s at revision %s: %v: %s", path, rev, err, stderr)
+		}
+		return io.NopCloser(bytes.NewReader(out)), nil
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitSince(t *testing.T) {
+	old := validEntry + strings.Replace(validEntry, "0001", "0002", 1)
+	current := validEntry +
+		strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "to eat", "to dine", 1) +
+		strings.Replace(validEntry, "0001", "0003", 1)
+	dir, input := writeInput(t, current)
+	output := filepath.Join(dir, "output.csv")
+	defer func(content func(string) func(string) (io.ReadCloser, error)) { gitContent = content }(gitContent)
+	gitContent = func(rev string) func(string) (io.ReadCloser, error) {
+		return func(path string) (io.ReadCloser, error) {
+			if rev != "HEAD~1" || path != input {
+				t.Errorf("unexpected revision or path: %s:%s", rev, path)
+			}
+			return io.NopCloser(strings.NewReader(old)), nil
+		}
+	}
+	if err := run([]string{"-p", "TEST", "-git-since", "HEAD~1", input, output}, io.Discard, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("failed to read output file: %v", err)
+	}
+	rows := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(rows) != 2 || !strings.HasPrefix(rows[0], "TEST-0002\t") || !strings.HasPrefix(rows[1], "TEST-0003\t") {
+		t.Errorf("expected only the changed and added entries, got %q", rows)
+	}
+}
+
+func TestGitSinceTagsFile(t *testing.T) {
+	dir, input := writeInput(t, validEntry+strings.Replace(validEntry, "0001", "0002", 1))
+	tags := filepath.Join(dir, "tags.tsv")
+	if err := os.WriteFile(tags, []byte("0001\tn5\n"), 0644); err != nil {
+		t.Fatalf("failed to write tags file: %v", err)
+	}
+	output := filepath.Join(dir, "output.csv")
+	defer func(content func(string) func(string) (io.ReadCloser, error)) { gitContent = content }(gitContent)
+	gitContent = func(rev string) func(string) (io.ReadCloser, error) {
+		return func(path string) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(validEntry)), nil
+		}
+	}
+	if err := run([]string{"-p", "TEST", "-tags-file", tags, "-git-since", "HEAD", input, output}, io.Discard, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("failed to read output file: %v", err)
+	}
+	rows := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(rows) != 1 || !strings.HasPrefix(rows[0], "TEST-0002\t") {
+		t.Errorf("expected only the added entry, got %q", rows)
+	}
+}
+
+func TestGitShow(t *testing.T) {
+	if _, err := GitShow("--output=/dev/null")("entries.txt"); err == nil {
+		t.Errorf("revision starting with a dash was accepted")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, input := writeInput(t, validEntry)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", filepath.Base(input)},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "entries"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v: %s", args[0], err, out)
+		}
+	}
+	for path, expected := range map[string]string{
+		input:                         validEntry,
+		filepath.Join(dir, "new.txt"): "",
+	} {
+		f, err := GitShow("HEAD")(path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", path, err)
+			continue
+		}
+		data, _ := io.ReadAll(f)
+		f.Close()
+		if string(data) != expected {
+			t.Errorf("%s: got %q, expected %q", path, data, expected)
+		}
+	}
+	if _, err := GitShow("no-such-revision")(input); err == nil {
+		t.Errorf("unknown revision was accepted")
+	}
+}
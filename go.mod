@@ -1,3 +1,5 @@
 module github.com/syphoxy/jyuuyou2200
 
-go 1.13
+go 1.23
+
+require golang.org/x/text v0.3.8
@@ -1,11 +1,15 @@
 package main
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestInvalidHTML(t *testing.T) {
 	for _, input := range []string{
 		"<p>1 < 5</p>",
 		"<p>1 <> 5</p>",
+		"1 < 5</p>",
 	} {
 		if err := IsValidHTML(input); err != nil {
 			t.Logf("%s: %v", input, err)
@@ -14,3 +18,54 @@ func TestInvalidHTML(t *testing.T) {
 		}
 	}
 }
+
+func TestVoidElements(t *testing.T) {
+	for input, expected := range map[string]int{
+		"to eat<br>to have a meal":     1,
+		"<b>to eat<BR>to dine</b>":     2,
+		"a<hr/>b<img src=\"x.png\" />": 1,
+		"<p>1<br/>2</p>":               2,
+	} {
+		depth, err := HTMLDepth(input)
+		if err != nil || depth != expected {
+			t.Errorf("%s: got depth %d and %v, expected depth %d", input, depth, err, expected)
+		}
+	}
+	if err := IsValidHTML("to eat</br>"); err == nil {
+		t.Errorf("close tag of a void element was accepted")
+	}
+}
+
+func TestStripHTML(t *testing.T) {
+	for input, expected := range map[string]string{
+		"<p>plain</p>":               "plain",
+		"<ruby>食<rt>た</rt></ruby>べる": "食たべる",
+		"1 &lt; 5 &amp;&nbsp;x":      "1 < 5 &\u00a0x",
+		"no markup":                  "no markup",
+	} {
+		if got := StripHTML(input); got != expected {
+			t.Errorf("%s: got %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestCollapseSpace(t *testing.T) {
+	for input, expected := range map[string]string{
+		"to  eat":                          "to eat",
+		"食べる　　ご飯":                          "食べる ご飯",
+		" 　 padded\t ":                     " padded ",
+		`<span  title="a  b">x   y</span>`: `<span  title="a  b">x y</span>`,
+		"no doubled spaces":                "no doubled spaces",
+	} {
+		if got := CollapseSpace(input); got != expected {
+			t.Errorf("%q: got %q, expected %q", input, got, expected)
+		}
+	}
+	entries, err := NewEntriesFromFileWithOptions(strings.NewReader(strings.Replace(validEntry, "to eat", "to　 eat", 1)), ParseOptions{CollapseSpace: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := entries[0].Definition(); got != "to eat" {
+		t.Errorf("got %q, expected %q", got, "to eat")
+	}
+}
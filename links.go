@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DefaultLinkTimeout is the time allowed for each link check when none is
+// configured.
+const DefaultLinkTimeout = 10 * time.Second
+
+var urlRegexp = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// LinkOptions configures CheckLinks.
+type LinkOptions struct {
+	// Client sends the requests. Defaults to a client using Timeout.
+	Client *http.Client
+	// Timeout limits each request when Client is nil. Defaults to
+	// DefaultLinkTimeout.
+	Timeout time.Duration
+	// Concurrency limits the number of requests in flight. Defaults to 1.
+	Concurrency int
+}
+
+// CheckLinks sends a HEAD request to each URL found in the ID line comments
+// and adds a warning to the entries whose links are unreachable. It returns
+// the number of distinct links checked.
+func (entries *Entries) CheckLinks(opts LinkOptions) int {
+	client := opts.Client
+	if client == nil {
+		timeout := opts.Timeout
+		if timeout <= 0 {
+			timeout = DefaultLinkTimeout
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make(map[string]error)
+	entries.Each(func(e Entry) {
+		for _, url := range urlRegexp.FindAllString(e.Comment(), -1) {
+			results[url] = nil
+		}
+	})
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, concurrency)
+	)
+	for url := range results {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(url string) {
+			defer func() { <-sem; wg.Done() }()
+			err := checkLink(client, url)
+			mu.Lock()
+			results[url] = err
+			mu.Unlock()
+		}(url)
+	}
+	wg.Wait()
+	for index := range entries {
+		e := &entries[index]
+		if e.ID() == 0 {
+			continue
+		}
+		for _, url := range urlRegexp.FindAllString(e.Comment(), -1) {
+			if err := results[url]; err != nil {
+				e.warnings = append(e.warnings, fmt.Sprintf("unreachable link: %s: %v", url, err))
+			}
+		}
+	}
+	return len(results)
+}
+
+func checkLink(client *http.Client, url string) error {
+	resp, err := client.Head(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
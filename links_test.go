@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckLinks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("unexpected method: %s", r.Method)
+		}
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	input := strings.Replace(validEntry, "0001", "0001  from "+server.URL+"/found", 1) +
+		strings.Replace(validEntry, "0001", "0002  from "+server.URL+"/missing", 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if checked := entries.CheckLinks(LinkOptions{Client: server.Client(), Concurrency: 2}); checked != 2 {
+		t.Errorf("checked %d links, expected 2", checked)
+	}
+	if warnings := entries[0].Warnings(); len(warnings) != 0 {
+		t.Errorf("0001: unexpected warnings: %v", warnings)
+	}
+	if warnings := entries[1].Warnings(); len(warnings) != 1 || !strings.Contains(warnings[0], "404") {
+		t.Errorf("0002: expected an unreachable link warning, got %v", warnings)
+	} else {
+		t.Log(warnings[0])
+	}
+	if entries[1].IsDirty() {
+		t.Errorf("0002: unreachable link marked the entry dirty")
+	}
+}
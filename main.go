@@ -2,16 +2,30 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
+	"html"
 	"io"
 	"log"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+
+	unicodeenc "golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
 )
 
 const (
@@ -26,10 +40,23 @@ const (
 
 	EntryDirtyMarker = byte('*')
 	EntryDelimiter   = "---"
+	// EntryCommentPrefix separates the dirty marker slot of an ID line from
+	// its comment unless ParseOptions.CommentPrefix is set.
+	EntryCommentPrefix = " "
+	// EntryIDPlaceholder stands in for the digits of an ID line whose ID is
+	// assigned when parsing with ParseOptions.AutoID.
+	EntryIDPlaceholder = "----"
+	EntryContinuation  = "\\"
+
+	DefaultMaxLineSize = 1024 * 1024
 )
 
 var ClozeDeletionRegexp = regexp.MustCompile("{{c[[:digit:]]::(.+)}}")
 
+// DefaultLayout names the fields that follow the ID line of each entry, in
+// order.
+var DefaultLayout = []string{"usage", "translation", "word", "pronunciation", "definition", "tags"}
+
 type EntriesParseError struct {
 	line   int
 	data   string
@@ -40,10 +67,34 @@ func (e EntriesParseError) Data() string  { return e.data }
 func (e EntriesParseError) Line() int     { return e.line }
 func (e EntriesParseError) Error() string { return e.reason }
 
+// EntriesParseErrors holds the errors collected while parsing with
+// ParseOptions.MaxErrors.
+type EntriesParseErrors struct {
+	Errors []EntriesParseError
+	// Stopped is set when parsing stopped at the limit, so more errors may
+	// follow in the input.
+	Stopped bool
+}
+
+func (e EntriesParseErrors) Error() string {
+	reasons := make([]string, len(e.Errors))
+	for index, err := range e.Errors {
+		reasons[index] = err.Error()
+	}
+	if e.Stopped {
+		reasons = append(reasons, fmt.Sprintf("stopped after %d errors (more may exist)", len(e.Errors)))
+	}
+	return strings.Join(reasons, "\n")
+}
+
 type Entry struct {
 	id            int64
 	dirty         bool
+	marked        bool
+	flagged       bool
+	comment       string
 	comments      []string
+	warnings      []string
 	input         string
 	usage         string
 	translation   string
@@ -51,11 +102,38 @@ type Entry struct {
 	pronunciation string
 	definition    string
 	tags          []string
+	// fields holds the fields in the layout without a dedicated member.
+	fields map[string]string
+	layout []string
+	plain  []string
+	// separator is the line ending the entry; empty means EntryDelimiter.
+	separator string
+	// commentPrefix precedes the ID line comment; empty means
+	// EntryCommentPrefix.
+	commentPrefix string
+	// autoID is set when the ID was assigned in place of a placeholder.
+	autoID bool
+	// modified is the time the entry was last edited, if known.
+	modified time.Time
+	// line is the source line holding the entry's ID line.
+	line int
+	// spans holds the source lines of the ID line, each field in the layout
+	// and the delimiter, including continuation lines.
+	spans []lineSpan
+	// file is the path of the input file the entry was read from, if known.
+	file string
 }
 
 func (e Entry) ID() int64                  { return e.id }
+func (e Entry) Modified() time.Time        { return e.modified }
+func (e Entry) Line() int                  { return e.line }
+func (e Entry) File() string               { return e.file }
 func (e Entry) IsDirty() bool              { return e.dirty }
+func (e Entry) IsMarked() bool             { return e.marked }
+func (e Entry) IsFlagged() bool            { return e.flagged }
+func (e Entry) Comment() string            { return e.comment }
 func (e Entry) Comments() []string         { return e.comments }
+func (e Entry) Warnings() []string         { return e.warnings }
 func (e Entry) Input() string              { return e.input }
 func (e Entry) Usage() string              { return e.usage }
 func (e Entry) Translation() string        { return e.translation }
@@ -63,224 +141,2458 @@ func (e Entry) Word() string               { return e.word }
 func (e Entry) Pronunciation() string      { return e.pronunciation }
 func (e Entry) Definition() string         { return e.definition }
 func (e Entry) Tags() []string             { return e.tags }
-func (e Entry) Audio(prefix string) string { return fmt.Sprintf("[sound:%s-%04d.mp3]", prefix, e.id) }
+func (e Entry) Audio(prefix string) string { return fmt.Sprintf("[sound:%s]", e.AudioFile(prefix)) }
+
+// AudioFile returns the name of the entry's audio file in the media folder.
+func (e Entry) AudioFile(prefix string) string { return fmt.Sprintf("%s-%04d.mp3", prefix, e.id) }
+
+// GUID returns an identifier for the entry's note that is derived from the
+// prefix and ID alone, so it is stable across runs.
+func (e Entry) GUID(prefix string) string {
+	return cardGUID(fmt.Sprintf("%s-%04d", prefix, e.id))
+}
 
-func (e Entry) CSV(prefix string) []string {
-	return []string{
-		fmt.Sprintf("%s-%04d", prefix, e.ID()),
-		e.Input(),
-		e.Usage(),
-		e.Translation(),
-		e.Word(),
-		e.Pronunciation(),
-		e.Definition(),
-		e.Audio(prefix),
-		strings.Join(e.Tags(), ","),
+// cardGUID returns the note GUID for a card ID.
+func cardGUID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Field returns the value of the named field. It returns false if there is no
+// field with that name.
+func (e Entry) Field(name string) (string, bool) {
+	switch name {
+	case "input":
+		return e.Input(), true
+	case "usage":
+		return e.Usage(), true
+	case "translation":
+		return e.Translation(), true
+	case "word":
+		return e.Word(), true
+	case "pronunciation":
+		return e.Pronunciation(), true
+	case "definition":
+		return e.Definition(), true
+	case "tags":
+		return strings.Join(e.Tags(), ","), true
 	}
+	value, ok := e.fields[name]
+	return value, ok
 }
 
-type Entries [2200]Entry
+func (e Entry) fieldLayout() []string {
+	if e.layout == nil {
+		return DefaultLayout
+	}
+	return e.layout
+}
 
-func (entries Entries) Write(f io.Writer, prefix string) (int, int, error) {
-	w := csv.NewWriter(f)
-	w.Comma = '\t'
-	count, dirty := 0, 0
-	for _, entry := range entries {
-		if entry.IsDirty() {
-			dirty++
+// extraFields returns the names of the fields in the entry's layout without a
+// dedicated member, in layout order.
+func (e Entry) extraFields() []string {
+	names := make([]string, 0)
+	for _, name := range e.fieldLayout() {
+		if _, ok := e.fields[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Equal reports whether e and other hold the same entry: the same ID, mark,
+// comment, fields, tags and modification time. The results of validation are
+// not compared, since they depend on the validators run, and neither are the
+// file and line an entry was read from, so the same entry read from another
+// source or revision is still equal.
+func (e Entry) Equal(other Entry) bool {
+	return e.id == other.id &&
+		e.marked == other.marked &&
+		e.comment == other.comment &&
+		e.input == other.input &&
+		e.usage == other.usage &&
+		e.translation == other.translation &&
+		e.word == other.word &&
+		e.pronunciation == other.pronunciation &&
+		e.definition == other.definition &&
+		e.modified.Equal(other.modified) &&
+		equalStrings(e.tags, other.tags) &&
+		equalFields(e.fields, other.fields)
+}
+
+// lineSpan is the range of source lines, counted from 1, that a line of an
+// entry was read from.
+type lineSpan struct{ first, last int }
+
+// span returns the source lines the named field was read from. It returns
+// false if the entry was not read from a file or has no such field.
+func (e Entry) span(name string) (lineSpan, bool) {
+	for index, field := range e.fieldLayout() {
+		if field == name && index+1 < len(e.spans) {
+			return e.spans[index+1], true
+		}
+	}
+	return lineSpan{}, false
+}
+
+func equalFields(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if other, ok := b[name]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Source returns the lines representing the entry in an input file. Only the
+// dirty marker and comment from the ID line are kept since validation
+// comments are regenerated when the lines are parsed again.
+func (e Entry) Source() []string {
+	marker := ' '
+	if e.marked {
+		marker = rune(EntryDirtyMarker)
+	}
+	id := fmt.Sprintf("%04d%c", e.id, marker)
+	if e.comment != "" {
+		id += e.idCommentPrefix() + e.comment
+	}
+	lines := []string{strings.TrimRight(id, " ")}
+	for _, name := range e.fieldLayout() {
+		value, _ := e.Field(name)
+		lines = append(lines, value)
+	}
+	return append(lines, e.recordSeparator())
+}
+
+// idCommentPrefix returns the text between the dirty marker slot and the
+// comment of the entry's ID line.
+func (e Entry) idCommentPrefix() string {
+	if e.commentPrefix == "" {
+		return EntryCommentPrefix
+	}
+	return e.commentPrefix
+}
+
+// recordSeparator returns the line that ends the entry in an input file.
+func (e Entry) recordSeparator() string {
+	if e.separator == "" {
+		return EntryDelimiter
+	}
+	return e.separator
+}
+
+// csvTagsColumn is the index of the tags column in the rows returned by
+// Entry.CSV.
+const csvTagsColumn = 8
+
+// CSV returns the output row for the entry. Fields in the layout without a
+// dedicated column are appended after the tags.
+func (e Entry) CSV(opts WriteOptions) []string {
+	row := []string{
+		e.cardID(opts),
+		e.escaped("usage", e.Input()),
+		e.escaped("usage", e.Usage()),
+		e.escaped("translation", e.Translation()),
+		e.escaped("word", e.Word()),
+		e.escaped("pronunciation", e.Pronunciation()),
+		e.escaped("definition", e.Definition()),
+		e.cardAudio(opts),
+		e.outputTags(opts),
+	}
+	for _, name := range e.extraFields() {
+		row = append(row, e.escaped(name, e.fields[name]))
+	}
+	if opts.EmptyPlaceholder != "" {
+		for index, value := range row {
+			// The tags column is left alone since the placeholder would
+			// become a tag.
+			if value == "" && index != csvTagsColumn {
+				row[index] = opts.EmptyPlaceholder
+			}
+		}
+	}
+	if opts.NoTagColumn {
+		row = append(row[:csvTagsColumn], row[csvTagsColumn+1:]...)
+	}
+	return row
+}
+
+// IsPlain reports whether the named field holds plain text rather than HTML.
+func (e Entry) IsPlain(name string) bool {
+	return containsString(e.plain, name)
+}
+
+// escaped returns value, HTML escaped if the named field holds plain text.
+func (e Entry) escaped(name, value string) string {
+	if e.IsPlain(name) {
+		return html.EscapeString(value)
+	}
+	return value
+}
+
+// cardPrefix returns the prefix of the entry's card ID and media files.
+func (e Entry) cardPrefix(opts WriteOptions) string {
+	if !opts.PrefixFromFilename || e.file == "" {
+		return opts.Prefix
+	}
+	name := strings.TrimSuffix(filepath.Base(e.file), filepath.Ext(e.file))
+	return opts.Prefix + "-" + strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.IsSpace(r) || strings.ContainsRune(`/\:*?"<>|[]`, r) {
+			return '_'
 		}
-		if entry.ID() == 0 || entry.IsDirty() {
+		return r
+	}, name)
+}
+
+// cardID returns the entry's card ID, offset by opts.IDBase.
+func (e Entry) cardID(opts WriteOptions) string {
+	return fmt.Sprintf("%s-%04d", e.cardPrefix(opts), e.ID()+opts.IDBase)
+}
+
+// cardAudioFile returns the name of the entry's audio file, numbered like its
+// card ID.
+func (e Entry) cardAudioFile(opts WriteOptions) string { return e.cardID(opts) + ".mp3" }
+
+// cardAudio returns the audio column for the entry.
+func (e Entry) cardAudio(opts WriteOptions) string {
+	return fmt.Sprintf("[sound:%s]", e.cardAudioFile(opts))
+}
+
+// outputTags returns the tags column for the entry.
+func (e Entry) outputTags(opts WriteOptions) string {
+	tags, defaults := e.Tags(), opts.DefaultTags
+	if opts.LowerTags {
+		tags, defaults = lowerTags(tags), lowerTags(defaults)
+	}
+	if opts.TagHierarchySeparator != "" {
+		tags, defaults = nestTags(tags, opts.TagHierarchySeparator), nestTags(defaults, opts.TagHierarchySeparator)
+	}
+	merged := MergeTags(tags, defaults)
+	if opts.SortTags {
+		sort.Strings(merged)
+	}
+	return strings.Join(merged, ",")
+}
+
+// nestTags replaces sep with the Anki hierarchy separator "::" in tags.
+func nestTags(tags []string, sep string) []string {
+	nested := make([]string, len(tags))
+	for index, tag := range tags {
+		nested[index] = strings.ReplaceAll(tag, sep, "::")
+	}
+	return nested
+}
+
+func lowerTags(tags []string) []string {
+	lowered := make([]string, len(tags))
+	for index, tag := range tags {
+		lowered[index] = strings.ToLower(tag)
+	}
+	return lowered
+}
+
+// MergeTags returns tags followed by each of defaults not already present.
+// Empty tags are dropped when there are defaults.
+func MergeTags(tags, defaults []string) []string {
+	merged := make([]string, 0, len(tags)+len(defaults))
+	seen := make(map[string]bool, len(tags)+len(defaults))
+	for _, tag := range append(append([]string{}, tags...), defaults...) {
+		if seen[tag] || (tag == "" && len(defaults) != 0) {
 			continue
 		}
-		if err := w.Write(entry.CSV(prefix)); err != nil {
-			return count, dirty, fmt.Errorf("failed to write csv data: %w", err)
+		seen[tag] = true
+		merged = append(merged, tag)
+	}
+	return merged
+}
+
+type Entries [2200]Entry
+
+type WriteOptions struct {
+	Prefix string
+	// PrefixFromFilename appends the base name of each entry's input file
+	// to Prefix, so that "verbs.txt" gives "<Prefix>-verbs".
+	PrefixFromFilename bool
+	// IDBase is added to the entry IDs in card IDs and audio file names,
+	// so that the cards do not collide with those of another deck.
+	IDBase int64
+	// Format is one of the Format constants. Empty means FormatCSV.
+	Format string
+	// DefaultTags are appended to the tags of every entry.
+	DefaultTags []string
+	// LowerTags lowercases tags so differently capitalized tags are merged.
+	LowerTags bool
+	// SortTags sorts the tags of each entry, after any default tags are
+	// added.
+	SortTags bool
+	// TagHierarchySeparator is replaced by "::" in tags so that they nest as
+	// hierarchical tags in Anki. Empty leaves tags unchanged.
+	TagHierarchySeparator string
+	// Limit is the maximum number of entries written. Zero means no limit.
+	Limit int
+	// Sort is SortID or SortFrequency. Empty means SortID.
+	Sort string
+	// Frequencies maps words to their frequency rank. When set, a column
+	// with the rank of each entry's word is appended, left empty for words
+	// without one.
+	Frequencies map[string]int
+	// Shuffle writes the entries in a pseudo-random order determined by
+	// Seed instead of ascending ID order.
+	Shuffle bool
+	Seed    int64
+	// GUID adds a leading column with a stable note GUID so that Anki updates
+	// existing notes on import.
+	GUID bool
+	// BOM prefixes the output with a UTF-8 byte order mark, which Excel needs
+	// to detect the encoding.
+	BOM bool
+	// Modified appends a column with each entry's modification time in RFC
+	// 3339 format, left empty when unknown.
+	Modified bool
+	// EmptyPlaceholder replaces empty fields in FormatCSV rows, except for
+	// the tags.
+	EmptyPlaceholder string
+	// NoTagColumn leaves the tags column out of the rows of the csv,
+	// listening and basic formats, for use with WriteTags.
+	NoTagColumn bool
+	// Directives writes header lines configuring Anki's import before the
+	// rows of the csv, listening and basic formats.
+	Directives *AnkiDirectives
+	// SplitClozes writes a row for each cloze number in the usage of an
+	// entry, as returned by Entry.ClozeVariants, with the cloze number
+	// appended to the card ID.
+	SplitClozes bool
+	// EmitLine appends a column with the source line of each entry's ID
+	// line.
+	EmitLine bool
+	// CRLF ends CSV rows with \r\n instead of \n.
+	CRLF bool
+	// EscapeNewlines replaces newlines within CSV fields with <br> instead of
+	// writing them quoted, for importers that treat each line as a row.
+	EscapeNewlines bool
+	// Baseline holds previously written rows keyed by card ID. Rows identical
+	// to their baseline row are not written.
+	Baseline map[string][]string
+	// Previous holds the entries of an earlier version of the input.
+	// Entries equal to their previous version are not written.
+	Previous *Entries
+	// Exclude holds words whose entries are not written.
+	Exclude map[string]bool
+	// QuizletTerm and QuizletDefinition name the fields written by
+	// FormatQuizlet. Empty means "word" and "definition".
+	QuizletTerm       string
+	QuizletDefinition string
+	// QuizletRowSeparator separates FormatQuizlet rows. Empty means a newline.
+	QuizletRowSeparator string
+	// written, if not nil, is called with each entry once it is written.
+	written func(Entry)
+}
+
+// NewExcludeFromFile reads a list of words, one per line, for use as
+// WriteOptions.Exclude. Blank lines are ignored.
+func NewExcludeFromFile(f io.Reader) (map[string]bool, error) {
+	return readWordList(f)
+}
+
+// readWordList reads the set of non-blank lines in f, with surrounding
+// whitespace trimmed.
+func readWordList(f io.Reader) (map[string]bool, error) {
+	words := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if word := strings.TrimSpace(scanner.Text()); word != "" {
+			words[word] = true
 		}
-		count++
 	}
-	w.Flush()
-	if err := w.Error(); err != nil {
-		return count, dirty, fmt.Errorf("failed to flush data: %w", err)
+	if err := scanner.Err(); err != nil {
+		return words, fmt.Errorf("failed to read file: %v", err)
 	}
-	return count, dirty, nil
+	return words, nil
 }
 
-func NewEntriesFromFile(f io.Reader) (Entries, error) {
-	const (
-		digitsOffset  = 3
-		dirtyOffset   = digitsOffset + 1
-		commentOffset = dirtyOffset + 2
-	)
-	entries := Entries{}
+// NewFrequenciesFromFile reads rows of "word<TAB>rank" for use as
+// WriteOptions.Frequencies.
+func NewFrequenciesFromFile(f io.Reader) (map[string]int, error) {
+	frequencies := make(map[string]int)
 	scanner := bufio.NewScanner(f)
-	current := Entry{}
 	for line := 1; scanner.Scan(); line++ {
 		data := scanner.Text()
-		switch (line - 1) % (EntryEnd + 1) {
-		case EntryID:
-			if len(data) < digitsOffset+1 {
-				return entries, EntriesParseError{
-					line: line,
-					data: data,
-					reason: fmt.Sprintf(
-						"line %d: entry ID too short: %q: found %d digits, expected %d digits",
-						line,
-						data,
-						len(data),
-						digitsOffset+1,
-					),
-				}
-			}
-			id, err := strconv.ParseInt(data[:digitsOffset+1], 10, 0)
-			if err != nil {
-				return entries, EntriesParseError{
-					line:   line,
-					data:   data,
-					reason: fmt.Sprintf("line %d: failed to parse entry ID: %q: %v", line, data, err),
-				}
-			}
-			current.id = id
-			current.dirty = len(data) >= dirtyOffset+1 && data[dirtyOffset] == EntryDirtyMarker
-			current.comments = make([]string, 0)
-			if len(data) >= commentOffset+1 {
-				current.comments = append(current.comments, data[commentOffset:])
+		if strings.TrimSpace(data) == "" {
+			continue
+		}
+		fields := strings.SplitN(data, "\t", 2)
+		if len(fields) != 2 {
+			return frequencies, EntriesParseError{
+				line:   line,
+				data:   data,
+				reason: fmt.Sprintf("line %d: expected word and rank separated by a tab: %q", line, data),
 			}
-		case EntryUsage:
-			current.usage = data
-			if matches := ClozeDeletionRegexp.FindStringSubmatch(data); matches != nil && len(matches) >= 2 {
-				current.input = matches[1]
-			} else {
-				current.dirty = true
-				current.comments = append(current.comments, "usage is missing cloze deletion.")
-			}
-			if err := IsValidHTML(data); err != nil {
-				current.dirty = true
-				current.comments = append(current.comments, err.Error())
-			}
-		case EntryTranslation:
-			current.translation = data
-			if ClozeDeletionRegexp.FindStringSubmatch(data) == nil {
-				current.dirty = true
-				current.comments = append(current.comments, "translation is missing cloze deletion.")
-			}
-			if err := IsValidHTML(data); err != nil {
-				current.dirty = true
-				current.comments = append(current.comments, err.Error())
-			}
-		case EntryWord:
-			current.word = data
-		case EntryPronunciation:
-			current.pronunciation = data
-		case EntryDefinition:
-			current.definition = data
-		case EntryTags:
-			current.tags = strings.Split(data, ",")
-		case EntryEnd:
-			if data != EntryDelimiter {
-				return entries, EntriesParseError{
-					line:   line,
-					data:   data,
-					reason: fmt.Sprintf("line %d: unexpected end of entry. found: %q, expected: %q", line, data, EntryDelimiter),
-				}
+		}
+		rank, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+		if err != nil {
+			return frequencies, EntriesParseError{
+				line:   line,
+				data:   data,
+				reason: fmt.Sprintf("line %d: failed to parse rank: %q: %v", line, data, err),
 			}
-			entries[current.id-1] = current
-			current = Entry{}
 		}
+		frequencies[strings.TrimSpace(fields[0])] = rank
 	}
 	if err := scanner.Err(); err != nil {
-		return entries, fmt.Errorf("failed to read file: %v", err)
+		return frequencies, fmt.Errorf("failed to read file: %v", err)
 	}
-	return entries, nil
+	return frequencies, nil
 }
 
-func main() {
-	cli := struct {
-		prefix string
-	}{}
-	flag.StringVar(&cli.prefix, "p", "JLPT-N2-JY-2200", "prefix for card IDs and media files")
-	flag.Parse()
-	if len(flag.Args()) != 2 {
-		log.Fatalf("invalid number of arguments: usage: %s input.txt output.csv", flag.CommandLine.Name())
+// ankiDirectiveRegexp matches a "#key:value" line written for
+// WriteOptions.Directives.
+var ankiDirectiveRegexp = regexp.MustCompile(`^#[a-z ]+:[^\t]*$`)
+
+// NewBaselineFromFile reads a previously generated output file for use as
+// WriteOptions.Baseline.
+func NewBaselineFromFile(f io.Reader) (map[string][]string, error) {
+	// Skip the leading lines written for WriteOptions.Directives. Rows may
+	// start with "#" too, when the prefix does.
+	br := bufio.NewReader(f)
+	var rows io.Reader = br
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read csv data: %w", err)
+		}
+		if !ankiDirectiveRegexp.MatchString(strings.TrimRight(line, "\r\n")) {
+			rows = io.MultiReader(strings.NewReader(line), br)
+			break
+		}
+		if err == io.EOF {
+			break
+		}
 	}
-	i, err := os.Open(flag.Arg(0))
-	if err != nil {
-		log.Fatalf("failed to open input file: %s: %v", flag.Arg(0), err)
+	r := csv.NewReader(rows)
+	r.Comma = '\t'
+	r.FieldsPerRecord = -1
+	baseline := make(map[string][]string)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return baseline, fmt.Errorf("failed to read csv data: %w", err)
+		}
+		baseline[record[0]] = record
 	}
-	defer i.Close()
-	entries, err := NewEntriesFromFile(i)
-	if err != nil {
-		log.Fatalf("failed to process input file: %v", err)
+	return baseline, nil
+}
+
+// Write writes each clean entry in ascending ID order using the configured
+// format, so the same entries and options always produce byte-identical
+// output. It returns the number of rows written and the number of dirty
+// entries.
+func (entries Entries) Write(f io.Writer, opts WriteOptions) (int, int, error) {
+	if opts.BOM {
+		if _, err := io.WriteString(f, "\uFEFF"); err != nil {
+			return 0, 0, fmt.Errorf("failed to write byte order mark: %w", err)
+		}
 	}
-	w, err := os.Create(flag.Arg(1))
-	if err != nil {
-		log.Fatalf("failed to open output file: %s: %v", flag.Arg(1), err)
+	switch opts.Format {
+	case "", FormatCSV:
+		return entries.writeCSV(f, opts, Entry.CSV, csvTagsColumn+1)
+	case FormatListening:
+		return entries.writeCSV(f, opts, Entry.ListeningCSV, 4)
+	case FormatBasic:
+		return entries.writeCSV(f, opts, Entry.BasicCSV, 4)
+	case FormatQuizlet:
+		return entries.writeQuizlet(f, opts)
+	case FormatText:
+		return entries.writeText(f, opts)
+	case FormatIndex:
+		return entries.writeIndex(f, opts)
+	case FormatJSONL:
+		return entries.writeJSONL(f, opts)
 	}
-	defer w.Close()
-	count, dirty, err := entries.Write(w, cli.prefix)
-	if err != nil {
-		log.Fatalf("failed to write output file: %v", err)
+	return 0, 0, fmt.Errorf("unknown format: %q", opts.Format)
+}
+
+// writeEach calls write for each clean entry in ascending ID order until
+// opts.Limit entries have been written. write reports whether it wrote the
+// entry. It returns the number of entries written and the number of dirty
+// entries.
+func (entries Entries) writeEach(opts WriteOptions, write func(Entry) (bool, error)) (int, int, error) {
+	count, dirty := 0, 0
+	order := make([]int, len(entries))
+	for index := range order {
+		order[index] = index
 	}
-	if dirty != 0 {
-		fmt.Println("found", dirty, "dirty entries.")
-		for _, entry := range entries {
-			if !entry.IsDirty() {
-				continue
+	if opts.Shuffle {
+		order = rand.New(rand.NewSource(opts.Seed)).Perm(len(entries))
+	}
+	if opts.Sort == SortFrequency {
+		sort.SliceStable(order, func(i, j int) bool {
+			a, aok := opts.Frequencies[strings.TrimSpace(entries[order[i]].Word())]
+			b, bok := opts.Frequencies[strings.TrimSpace(entries[order[j]].Word())]
+			return aok && (!bok || a < b)
+		})
+	}
+	for _, index := range order {
+		entry := entries[index]
+		if entry.IsDirty() {
+			dirty++
+		}
+		if entry.ID() == 0 || entry.IsDirty() || (opts.Limit > 0 && count >= opts.Limit) {
+			continue
+		}
+		if opts.Exclude[strings.TrimSpace(entry.Word())] {
+			continue
+		}
+		if opts.Previous != nil && opts.Previous[index].Equal(entry) {
+			continue
+		}
+		written, err := write(entry)
+		if err != nil {
+			return count, dirty, err
+		}
+		if written {
+			count++
+			if opts.written != nil {
+				opts.written(entry)
 			}
-			fmt.Print("\n")
-			if len(entry.Comments()) == 0 {
-				fmt.Printf("  %04d: marked.\n", entry.ID())
-				continue
+		}
+	}
+	return count, dirty, nil
+}
+
+var newlineReplacer = strings.NewReplacer("\r\n", "<br>", "\n", "<br>", "\r", "<br>")
+
+// writeCSV writes the rows returned by csvRow, whose tags are in the column
+// numbered tagsColumn from 1.
+func (entries Entries) writeCSV(f io.Writer, opts WriteOptions, csvRow func(Entry, WriteOptions) []string, tagsColumn int) (int, int, error) {
+	if opts.Directives != nil {
+		if err := opts.Directives.write(f, opts, tagsColumn); err != nil {
+			return 0, 0, err
+		}
+	}
+	w := csv.NewWriter(f)
+	w.Comma = '\t'
+	w.UseCRLF = opts.CRLF
+	writeRow := func(entry Entry, row []string) (bool, error) {
+		if opts.EscapeNewlines {
+			for index, value := range row {
+				row[index] = newlineReplacer.Replace(value)
 			}
-			for index, comment := range entry.Comments() {
-				if index == 0 {
-					fmt.Printf("  %04d: %s\n", entry.ID(), comment)
-				} else {
-					fmt.Println(strings.Repeat(" ", 2+4+1), comment)
-				}
+		}
+		if opts.GUID {
+			row = append([]string{cardGUID(row[0])}, row...)
+		}
+		if opts.Modified {
+			modified := ""
+			if !entry.Modified().IsZero() {
+				modified = entry.Modified().Format(time.RFC3339)
+			}
+			row = append(row, modified)
+		}
+		if opts.Frequencies != nil {
+			rank := ""
+			if n, ok := opts.Frequencies[strings.TrimSpace(entry.Word())]; ok {
+				rank = strconv.Itoa(n)
+			}
+			row = append(row, rank)
+		}
+		if opts.EmitLine {
+			row = append(row, strconv.Itoa(entry.Line()))
+		}
+		if previous, ok := opts.Baseline[row[0]]; ok && equalStrings(previous, row) {
+			return false, nil
+		}
+		if err := w.Write(row); err != nil {
+			return false, fmt.Errorf("failed to write csv data: %w", err)
+		}
+		return true, nil
+	}
+	count, dirty, err := entries.writeEach(opts, func(entry Entry) (bool, error) {
+		if !opts.SplitClozes {
+			return writeRow(entry, csvRow(entry, opts))
+		}
+		written := false
+		for _, variant := range entry.ClozeVariants() {
+			row := csvRow(variant.Entry, opts)
+			row[0] += "-" + variant.Cloze
+			ok, err := writeRow(variant.Entry, row)
+			if err != nil {
+				return written, err
 			}
+			written = written || ok
 		}
-		fmt.Print("\n")
+		return written, nil
+	})
+	if err != nil {
+		return count, dirty, err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return count, dirty, fmt.Errorf("failed to flush data: %w", err)
 	}
-	fmt.Println("generated", count, "entries.")
+	return count, dirty, nil
 }
 
-func IsValidHTML(s string) error {
-	tags := make([]string, 0)
-	for offset := 0; offset < len(s); {
-		start := strings.IndexByte(s[offset:], '<')
-		if start == -1 {
-			break
+// WriteSource writes the populated entries in the input file format.
+func (entries Entries) WriteSource(f io.Writer) error {
+	w := bufio.NewWriter(f)
+	for _, entry := range entries {
+		if entry.ID() == 0 {
+			continue
 		}
-		start += offset
-		if offset < start {
-			if strings.IndexByte(s[offset:start], '>') != -1 {
-				return errors.New("found closing bracket before an opening bracket")
+		for _, line := range entry.Source() {
+			w.WriteString(line)
+			w.WriteByte('\n')
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write source data: %w", err)
+	}
+	return nil
+}
+
+// WriteTags writes an "id<TAB>tags" row with the card ID and tags of each
+// entry that Write would write. It returns the number of rows written.
+func (entries Entries) WriteTags(f io.Writer, opts WriteOptions) (int, error) {
+	w := csv.NewWriter(f)
+	w.Comma = '\t'
+	w.UseCRLF = opts.CRLF
+	count, _, err := entries.writeEach(opts, func(entry Entry) (bool, error) {
+		if err := w.Write([]string{entry.cardID(opts), entry.outputTags(opts)}); err != nil {
+			return false, fmt.Errorf("failed to write tags data: %w", err)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return count, err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return count, fmt.Errorf("failed to flush data: %w", err)
+	}
+	return count, nil
+}
+
+// Each calls fn for each populated entry in ascending ID order.
+func (entries *Entries) Each(fn func(Entry)) {
+	for index := range entries {
+		if entries[index].ID() != 0 {
+			fn(entries[index])
+		}
+	}
+}
+
+// Map returns the populated entries keyed by ID.
+func (entries *Entries) Map() map[int64]Entry {
+	m := make(map[int64]Entry)
+	entries.Each(func(e Entry) {
+		m[e.ID()] = e
+	})
+	return m
+}
+
+// Renumber returns the populated entries with IDs reassigned from 1 in
+// ascending order, closing any gaps left by deleted entries.
+func (entries *Entries) Renumber() Entries {
+	renumbered := Entries{}
+	next := 0
+	entries.Each(func(e Entry) {
+		e.id = int64(next + 1)
+		renumbered[next] = e
+		next++
+	})
+	return renumbered
+}
+
+// Completeness returns, for each field, the fraction of populated entries in
+// which that field is non-empty.
+func (entries Entries) Completeness() map[string]float64 {
+	filled := make(map[string]int)
+	total := 0
+	for _, entry := range entries {
+		if entry.ID() == 0 {
+			continue
+		}
+		total++
+		for name, value := range map[string]string{
+			"usage":         entry.Usage(),
+			"translation":   entry.Translation(),
+			"word":          entry.Word(),
+			"pronunciation": entry.Pronunciation(),
+			"definition":    entry.Definition(),
+			"tags":          strings.Join(entry.Tags(), ""),
+		} {
+			if _, ok := filled[name]; !ok {
+				filled[name] = 0
+			}
+			if strings.TrimSpace(value) != "" {
+				filled[name]++
 			}
-			offset = start
 		}
-		end := strings.IndexByte(s[start:], '>')
-		if end == -1 {
-			return errors.New("found opening bracket but no closing bracket")
+	}
+	completeness := make(map[string]float64, len(filled))
+	for name, count := range filled {
+		completeness[name] = float64(count) / float64(total)
+	}
+	return completeness
+}
+
+// MergeTagsFromFile merges tags read from rows of "id<TAB>tag1,tag2" into the
+// corresponding entries. A warning is returned for each row whose ID has no
+// entry.
+func (entries *Entries) MergeTagsFromFile(f io.Reader) ([]string, error) {
+	return entries.scanIDRows(f, "tags", func(e *Entry, line int, data, value string) error {
+		e.tags = MergeTags(e.tags, strings.Split(value, ","))
+		return nil
+	})
+}
+
+// MergeModifiedFromFile sets the modification times read from rows of
+// "id<TAB>timestamp", with RFC 3339 timestamps, on the corresponding entries.
+// A warning is returned for each row whose ID has no entry.
+func (entries *Entries) MergeModifiedFromFile(f io.Reader) ([]string, error) {
+	return entries.scanIDRows(f, "timestamp", func(e *Entry, line int, data, value string) error {
+		modified, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+		if err != nil {
+			return EntriesParseError{
+				line:   line,
+				data:   data,
+				reason: fmt.Sprintf("line %d: failed to parse timestamp: %q: %v", line, data, err),
+			}
 		}
-		end += offset
-		tag := strings.TrimSpace(s[start+1 : end])
-		if idx := strings.IndexByte(tag, ' '); idx >= 0 {
-			tag = tag[:idx]
+		e.modified = modified
+		return nil
+	})
+}
+
+// scanIDRows calls fn with the entry and value of each "id<TAB>value" row read
+// from f, where what describes the value in errors.
+func (entries *Entries) scanIDRows(f io.Reader, what string, fn func(e *Entry, line int, data, value string) error) ([]string, error) {
+	warnings := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		data := scanner.Text()
+		if strings.TrimSpace(data) == "" {
+			continue
 		}
-		if tag == "" {
-			return errors.New("empty tag found")
+		fields := strings.SplitN(data, "\t", 2)
+		if len(fields) != 2 {
+			return warnings, EntriesParseError{
+				line:   line,
+				data:   data,
+				reason: fmt.Sprintf("line %d: expected id and %s separated by a tab: %q", line, what, data),
+			}
 		}
-		if tag[0] == '/' {
-			if last, expected := tags[len(tags)-1], tag[1:]; last != expected {
-				return fmt.Errorf("mismatched close tag found: %s != %s", last, expected)
+		id, err := strconv.ParseInt(strings.TrimSpace(fields[0]), 10, 0)
+		if err != nil {
+			return warnings, EntriesParseError{
+				line:   line,
+				data:   data,
+				reason: fmt.Sprintf("line %d: failed to parse entry ID: %q: %v", line, data, err),
 			}
-			tags = tags[:len(tags)-1]
-		} else {
-			tags = append(tags, tag)
 		}
-		offset = end + 1
+		if id < 1 || id > int64(len(entries)) || entries[id-1].ID() == 0 {
+			warnings = append(warnings, fmt.Sprintf("line %d: unknown entry ID: %d", line, id))
+			continue
+		}
+		if err := fn(&entries[id-1], line, data, fields[1]); err != nil {
+			return warnings, err
+		}
 	}
-	if len(tags) != 0 {
-		return fmt.Errorf("not all tags closed: %+v", tags)
+	if err := scanner.Err(); err != nil {
+		return warnings, fmt.Errorf("failed to read file: %v", err)
 	}
-	return nil
+	return warnings, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type ParseOptions struct {
+	// MaxLineSize is the maximum length in bytes of a single input line.
+	// Zero means DefaultMaxLineSize.
+	MaxLineSize int
+	// Validators are run on each entry once it has been parsed. Nil means
+	// DefaultValidators.
+	Validators []Validator
+	// Layout names the fields following the ID line of each entry. Nil means
+	// DefaultLayout.
+	Layout []string
+	// Hashtags moves "#tag" words from the ID line comment into the tags.
+	Hashtags bool
+	// StrictDelimiter disables trimming whitespace around delimiter lines.
+	StrictDelimiter bool
+	// RecordSeparator is the line ending each entry. Defaults to
+	// EntryDelimiter.
+	RecordSeparator string
+	// CommentPrefix separates the dirty marker slot of ID lines from their
+	// comment, both when parsing and in WriteSource. Defaults to
+	// EntryCommentPrefix.
+	CommentPrefix string
+	// CollapseSpace replaces runs of whitespace in fields, outside of HTML
+	// tags, with a single space.
+	CollapseSpace bool
+	// StripInvisible removes the characters reported by IsInvisible from
+	// fields, rather than leaving them for InvisibleValidator.
+	StripInvisible bool
+	// NormalizeNFC converts fields to Unicode normalization form C.
+	NormalizeNFC bool
+	// MaxErrors is the number of parse errors collected before parsing
+	// stops. Zero stops at the first error and returns it alone; otherwise an
+	// entry with an error is skipped up to its delimiter and the errors are
+	// returned together as EntriesParseErrors. Negative means no limit.
+	MaxErrors int
+	// AutoID assigns the lowest free IDs to entries whose ID line starts
+	// with EntryIDPlaceholder, in the order they appear.
+	AutoID bool
+	// PlainFields names the fields holding plain text, which are not
+	// validated as HTML and are escaped on output.
+	PlainFields []string
+}
+
+// ParseStats describes the input consumed while parsing entries.
+type ParseStats struct {
+	Lines   int
+	Bytes   int64
+	Entries int
+	Dirty   int
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func NewEntriesFromFile(f io.Reader) (Entries, error) {
+	entries, _, err := NewEntriesFromFileWithStats(f)
+	return entries, err
+}
+
+func NewEntriesFromFileWithOptions(f io.Reader, opts ParseOptions) (Entries, error) {
+	entries, _, err := parseEntries(context.Background(), f, opts, nil)
+	return entries, err
+}
+
+// NewEntriesFromFileWithStats is like NewEntriesFromFile but also describes
+// the input consumed.
+func NewEntriesFromFileWithStats(f io.Reader) (Entries, ParseStats, error) {
+	return parseEntries(context.Background(), f, ParseOptions{}, nil)
+}
+
+// parseEntries parses the entries read from r, returning early with ctx.Err()
+// once ctx is done. If yield is not nil, it is called with each entry as it is
+// completed and with each parse error that does not stop parsing; parsing
+// stops with errYieldStopped once it returns false.
+func parseEntries(ctx context.Context, r io.Reader, opts ParseOptions, yield func(Entry, error) bool) (Entries, ParseStats, error) {
+	maxLineSize := opts.MaxLineSize
+	if maxLineSize <= 0 {
+		maxLineSize = DefaultMaxLineSize
+	}
+	entries := Entries{}
+	f := &countingReader{r: r}
+	// A UTF-8 or UTF-16 byte order mark selects the decoding; input without
+	// one is read as UTF-8.
+	scanner := bufio.NewScanner(transform.NewReader(f, unicodeenc.BOMOverride(transform.Nop)))
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineSize)
+	validators := opts.Validators
+	if validators == nil {
+		validators = DefaultValidators()
+	}
+	layout := opts.Layout
+	if layout == nil {
+		layout = DefaultLayout
+	}
+	if err := ValidateLayout(layout); err != nil {
+		return entries, ParseStats{}, err
+	}
+	for _, name := range opts.PlainFields {
+		if !containsString(layout, name) {
+			return entries, ParseStats{}, fmt.Errorf("invalid plain field: %q: not in layout", name)
+		}
+	}
+	current := Entry{layout: layout, plain: opts.PlainFields, separator: opts.RecordSeparator, commentPrefix: opts.CommentPrefix}
+	type origin struct {
+		line int
+		data string
+	}
+	origins := make(map[int64]origin)
+	type placeholder struct {
+		entry  Entry
+		origin origin
+	}
+	unassigned := make([]placeholder, 0)
+	var placeholderOrigin origin
+	errs := make([]EntriesParseError, 0)
+	// fail records a parse error and returns the error to stop parsing with,
+	// or nil to skip the rest of the entry and carry on.
+	fail := func(err error) error {
+		perr, ok := err.(EntriesParseError)
+		if !ok || opts.MaxErrors == 0 {
+			return err
+		}
+		errs = append(errs, perr)
+		if opts.MaxErrors > 0 && len(errs) >= opts.MaxErrors {
+			return EntriesParseErrors{Errors: errs, Stopped: true}
+		}
+		if yield != nil && !yield(Entry{}, perr) {
+			return errYieldStopped
+		}
+		return nil
+	}
+	skipping := false
+	hashtags := []string{}
+	continued, pending := false, ""
+	first := 0
+	// replay is set to parse the current line again as the start of an
+	// entry.
+	replay := false
+	field := EntryID
+	line := 1
+	stats := func() ParseStats {
+		s := ParseStats{Lines: line - 1, Bytes: f.n}
+		entries.Each(func(e Entry) {
+			s.Entries++
+			if e.IsDirty() {
+				s.Dirty++
+			}
+		})
+		return s
+	}
+	for ; replay || scanner.Scan(); line++ {
+		replay = false
+		if line%64 == 0 {
+			if err := ctx.Err(); err != nil {
+				return entries, stats(), err
+			}
+		}
+		data := scanner.Text()
+		if skipping {
+			if strings.TrimSpace(data) == current.recordSeparator() {
+				skipping, continued = false, false
+				current = Entry{layout: layout, plain: opts.PlainFields, separator: opts.RecordSeparator, commentPrefix: opts.CommentPrefix}
+				field = EntryID
+			}
+			continue
+		}
+		if field == EntryID && strings.HasPrefix(strings.TrimSpace(data), "#") {
+			continue
+		}
+		if continued {
+			data, continued = pending+"<br>"+data, false
+		} else {
+			first = line
+		}
+		if field != EntryID && field <= len(layout) && strings.HasSuffix(data, EntryContinuation) {
+			continued, pending = true, strings.TrimSuffix(data, EntryContinuation)
+			continue
+		}
+		if field == EntryID && opts.Hashtags {
+			data, hashtags = splitHashtags(data)
+		}
+		if field == len(layout)+1 && !opts.StrictDelimiter {
+			data = strings.TrimSpace(data)
+		}
+		if field != EntryID && field <= len(layout) && opts.StripInvisible {
+			data = StripInvisible(data)
+		}
+		if field != EntryID && field <= len(layout) && opts.NormalizeNFC {
+			data = norm.NFC.String(data)
+		}
+		if field != EntryID && field <= len(layout) && opts.CollapseSpace {
+			data = CollapseSpace(data)
+		}
+		if field == EntryID && opts.AutoID && strings.HasPrefix(data, EntryIDPlaceholder) {
+			// Parse the rest of the line with a stand-in ID until a free
+			// one is assigned below.
+			if err := current.parseLine(field, line, "0001"+data[len(EntryIDPlaceholder):]); err != nil {
+				if err := fail(err); err != nil {
+					return entries, stats(), err
+				}
+				skipping = true
+				continue
+			}
+			current.autoID = true
+			current.spans = append(current.spans, lineSpan{first, line})
+			placeholderOrigin = origin{line, data}
+			field++
+			continue
+		}
+		if err := current.parseLine(field, line, data); err != nil {
+			if err := fail(err); err != nil {
+				return entries, stats(), err
+			}
+			if field == len(layout)+1 && isIDLine(data, opts) {
+				// The delimiter is missing, so the line starts the next
+				// entry rather than being skipped with this one.
+				current = Entry{layout: layout, plain: opts.PlainFields, separator: opts.RecordSeparator, commentPrefix: opts.CommentPrefix}
+				field = EntryID
+				replay, line = true, line-1
+				continue
+			}
+			skipping = true
+			continue
+		}
+		current.spans = append(current.spans, lineSpan{first, line})
+		if field == EntryID {
+			if previous, ok := origins[current.id]; ok {
+				err := fail(EntriesParseError{
+					line: line,
+					data: data,
+					reason: fmt.Sprintf(
+						"line %d: duplicate entry ID %04d: %q collides with %q on line %d",
+						line,
+						current.id,
+						data,
+						previous.data,
+						previous.line,
+					),
+				})
+				if err != nil {
+					return entries, stats(), err
+				}
+				skipping = true
+				continue
+			}
+			origins[current.id] = origin{line, data}
+		}
+		if field != len(layout)+1 {
+			field++
+			continue
+		}
+		if opts.Hashtags {
+			current.tags = MergeTags(current.tags, hashtags)
+		}
+		if problems := current.validate(validators); len(problems) != 0 {
+			id := fmt.Sprintf("%04d", current.id)
+			if current.autoID {
+				id = EntryIDPlaceholder
+			}
+			err := fail(EntriesParseError{
+				line:   current.line,
+				reason: fmt.Sprintf("line %d: entry %s: %s", current.line, id, strings.Join(problems, " ")),
+			})
+			if err != nil {
+				return entries, stats(), err
+			}
+			current = Entry{layout: layout, plain: opts.PlainFields, separator: opts.RecordSeparator, commentPrefix: opts.CommentPrefix}
+			field = EntryID
+			continue
+		}
+		if current.autoID {
+			unassigned = append(unassigned, placeholder{current, placeholderOrigin})
+		} else {
+			entries[current.id-1] = current
+			if yield != nil && !yield(current, nil) {
+				return entries, stats(), errYieldStopped
+			}
+		}
+		current = Entry{layout: layout, plain: opts.PlainFields, separator: opts.RecordSeparator, commentPrefix: opts.CommentPrefix}
+		field = EntryID
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return entries, stats(), EntriesParseError{
+				line:   line,
+				reason: fmt.Sprintf("line %d: line too long: exceeds %d bytes", line, maxLineSize),
+			}
+		}
+		return entries, stats(), fmt.Errorf("failed to read file: %v", err)
+	}
+	if !skipping && (field != EntryID || continued) {
+		err := fail(EntriesParseError{
+			line:   line - 1,
+			reason: fmt.Sprintf("line %d: unexpected end of file: entry %04d is incomplete", line-1, current.id),
+		})
+		if err != nil {
+			return entries, stats(), err
+		}
+	}
+	free := 0
+	for _, p := range unassigned {
+		for free < len(entries) && entries[free].ID() != 0 {
+			free++
+		}
+		if free == len(entries) {
+			err := fail(EntriesParseError{
+				line:   p.origin.line,
+				data:   p.origin.data,
+				reason: fmt.Sprintf("line %d: no free entry ID left for %q", p.origin.line, p.origin.data),
+			})
+			if err != nil {
+				return entries, stats(), err
+			}
+			continue
+		}
+		p.entry.id = int64(free + 1)
+		entries[free] = p.entry
+		if yield != nil && !yield(p.entry, nil) {
+			return entries, stats(), errYieldStopped
+		}
+	}
+	if len(errs) != 0 {
+		return entries, stats(), EntriesParseErrors{Errors: errs}
+	}
+	return entries, stats(), nil
+}
+
+// NewEntriesFromFiles parses each of the named files and merges their entries.
+// An entry ID used by more than one file is an error.
+func NewEntriesFromFiles(paths []string, opts ParseOptions) (Entries, error) {
+	return NewEntriesFromSources(paths, opts, func(path string) (io.ReadCloser, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open input file: %s: %v", path, err)
+		}
+		return f, nil
+	})
+}
+
+// NewEntriesFromSources is like NewEntriesFromFiles but reads each path with
+// open, such as GitShow to read the files at a revision.
+func NewEntriesFromSources(paths []string, opts ParseOptions, open func(path string) (io.ReadCloser, error)) (Entries, error) {
+	entries := Entries{}
+	owners := make(map[int64]string)
+	for _, path := range paths {
+		f, err := open(path)
+		if err != nil {
+			return entries, err
+		}
+		parsed, err := NewEntriesFromFileWithOptions(f, opts)
+		f.Close()
+		if err != nil {
+			return entries, fmt.Errorf("failed to process input file: %s: %w", path, err)
+		}
+		for _, entry := range parsed {
+			if entry.ID() == 0 {
+				continue
+			}
+			if owner, ok := owners[entry.ID()]; ok {
+				return entries, fmt.Errorf("duplicate entry ID %04d: found in %s and %s", entry.ID(), owner, path)
+			}
+			owners[entry.ID()] = path
+			entry.file = path
+			entries[entry.ID()-1] = entry
+		}
+	}
+	return entries, nil
+}
+
+// ValidateLayout ensures each field name in layout is unique and can be
+// stored in an Entry.
+func ValidateLayout(layout []string) error {
+	if len(layout) == 0 {
+		return errors.New("invalid layout: no fields")
+	}
+	seen := make(map[string]bool, len(layout))
+	for _, name := range layout {
+		if name == "" || name == "input" || seen[name] {
+			return fmt.Errorf("invalid layout: %q: invalid or duplicate field name %q", strings.Join(layout, ","), name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// ParseEntryBlock parses a single entry: its field lines optionally followed
+// by the delimiter. Both parse errors and validation problems are returned.
+func ParseEntryBlock(block string) (Entry, []error) {
+	entry := Entry{}
+	lines := strings.Split(strings.TrimSuffix(block, "\n"), "\n")
+	if len(lines) != EntryEnd && len(lines) != EntryEnd+1 {
+		return entry, []error{
+			fmt.Errorf("invalid number of lines: found %d, expected %d or %d", len(lines), EntryEnd, EntryEnd+1),
+		}
+	}
+	errs := make([]error, 0)
+	validated := 0
+	for index, data := range lines {
+		data = strings.TrimSuffix(data, "\r")
+		if index == EntryEnd {
+			data = strings.TrimSpace(data)
+		}
+		if err := entry.parseLine(index, index+1, data); err != nil {
+			errs = append(errs, err)
+		}
+		if index == EntryID {
+			validated = len(entry.comments)
+		}
+	}
+	entry.validate(DefaultValidators())
+	for _, comment := range entry.comments[validated:] {
+		errs = append(errs, errors.New(comment))
+	}
+	return entry, errs
+}
+
+const (
+	digitsOffset = 3
+	dirtyOffset  = digitsOffset + 1
+)
+
+func (e *Entry) parseLine(field, line int, data string) error {
+	layout := e.fieldLayout()
+	switch {
+	case field == EntryID:
+		id, marked, comment, err := parseIDLine(data, e.idCommentPrefix())
+		if err != nil {
+			return EntriesParseError{
+				line:   line,
+				data:   data,
+				reason: fmt.Sprintf("line %d: %v", line, err),
+			}
+		}
+		e.id, e.marked, e.comment = id, marked, comment
+		e.line = line
+		e.dirty = e.marked
+		e.comments = make([]string, 0)
+		if e.comment != "" {
+			e.comments = append(e.comments, e.comment)
+		}
+	case field <= len(layout):
+		e.setField(layout[field-1], data)
+	default:
+		if data != e.recordSeparator() {
+			return EntriesParseError{
+				line:   line,
+				data:   data,
+				reason: fmt.Sprintf("line %d: unexpected end of entry. found: %q, expected: %q", line, data, e.recordSeparator()),
+			}
+		}
+	}
+	return nil
+}
+
+// ParseIDLine parses an entry's ID line into the entry ID, whether the dirty
+// marker is set and the comment following it.
+func ParseIDLine(data string) (id int64, dirty bool, comment string, err error) {
+	return parseIDLine(data, EntryCommentPrefix)
+}
+
+// parseIDLine is like ParseIDLine with the comment following prefix.
+func parseIDLine(data, prefix string) (id int64, dirty bool, comment string, err error) {
+	if len(data) < digitsOffset+1 {
+		return 0, false, "", fmt.Errorf(
+			"entry ID too short: %q: found %d digits, expected %d digits",
+			data,
+			len(data),
+			digitsOffset+1,
+		)
+	}
+	id, err = strconv.ParseInt(data[:digitsOffset+1], 10, 0)
+	if err != nil {
+		return 0, false, "", fmt.Errorf("failed to parse entry ID: %q: %v", data, err)
+	}
+	if id == 0 {
+		return 0, false, "", fmt.Errorf("invalid entry ID: %q: ID 0 is reserved for empty slots", data)
+	}
+	if id < 0 || id > int64(len(Entries{})) {
+		return 0, false, "", fmt.Errorf("invalid entry ID: %q: expected 1 to %d", data, len(Entries{}))
+	}
+	if len(data) >= dirtyOffset+1 && data[dirtyOffset] != ' ' && data[dirtyOffset] != EntryDirtyMarker {
+		return 0, false, "", fmt.Errorf("malformed entry ID: %q: found %q in the dirty marker slot, expected %q or a space", data, data[dirtyOffset], EntryDirtyMarker)
+	}
+	dirty = len(data) >= dirtyOffset+1 && data[dirtyOffset] == EntryDirtyMarker
+	if len(data) <= dirtyOffset+1 {
+		return id, dirty, "", nil
+	}
+	// A prefix cut short by trimming trailing spaces leaves no comment.
+	rest := data[dirtyOffset+1:]
+	if strings.HasPrefix(rest, prefix) {
+		comment = rest[len(prefix):]
+	} else if !strings.HasPrefix(prefix, rest) {
+		return 0, false, "", fmt.Errorf("malformed entry ID: %q: expected %q before the comment", data, prefix)
+	}
+	return id, dirty, comment, nil
+}
+
+// isIDLine reports whether data would be parsed as an ID line with opts.
+func isIDLine(data string, opts ParseOptions) bool {
+	if opts.AutoID && strings.HasPrefix(data, EntryIDPlaceholder) {
+		return true
+	}
+	prefix := opts.CommentPrefix
+	if prefix == "" {
+		prefix = EntryCommentPrefix
+	}
+	if opts.Hashtags {
+		data, _ = splitHashtags(data)
+	}
+	_, _, _, err := parseIDLine(data, prefix)
+	return err == nil
+}
+
+// splitHashtags removes the "#tag" words following the dirty marker slot of an
+// ID line and returns them without the leading "#".
+func splitHashtags(data string) (string, []string) {
+	if len(data) <= dirtyOffset+1 {
+		return data, []string{}
+	}
+	tags, words := make([]string, 0), make([]string, 0)
+	for _, word := range strings.Fields(data[dirtyOffset+1:]) {
+		if len(word) > 1 && word[0] == '#' {
+			tags = append(tags, word[1:])
+		} else {
+			words = append(words, word)
+		}
+	}
+	if len(words) == 0 {
+		return data[:dirtyOffset+1], tags
+	}
+	return data[:dirtyOffset+1] + " " + strings.Join(words, " "), tags
+}
+
+func (e *Entry) setField(name, data string) {
+	switch name {
+	case "usage":
+		e.usage = data
+		if matches := ClozeDeletionRegexp.FindStringSubmatch(data); matches != nil && len(matches) >= 2 {
+			e.input = matches[1]
+		}
+	case "translation":
+		e.translation = data
+	case "word":
+		e.word = data
+	case "pronunciation":
+		e.pronunciation = data
+	case "definition":
+		e.definition = data
+	case "tags":
+		e.tags = strings.Split(data, ",")
+	default:
+		if e.fields == nil {
+			e.fields = make(map[string]string)
+		}
+		e.fields[name] = data
+	}
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		var usage usageError
+		if errors.As(err, &usage) {
+			if errors.Is(err, flag.ErrHelp) {
+				return
+			}
+			os.Exit(2)
+		}
+		log.Fatal(err)
+	}
+}
+
+// usageError is returned by run when the command line flags cannot be parsed.
+// The problem and the usage have already been written to stderr.
+type usageError struct{ err error }
+
+func (e usageError) Error() string { return e.err.Error() }
+func (e usageError) Unwrap() error { return e.err }
+
+func run(args []string, stdout, stderr io.Writer) error {
+	cli := struct {
+		prefix      string
+		prefixFile  bool
+		idBase      int64
+		maxLineSize int
+		defaultTags string
+		stats       bool
+		since       string
+		gitSince    string
+		lint        bool
+		fix         bool
+		autoID      bool
+		renumber    bool
+		diff        string
+		count       bool
+		showFields  bool
+		reportFmt   string
+		reportSort  string
+		tagsFile    string
+		mtimeFile   string
+		identical   bool
+		entities    bool
+		profile     string
+		structOnly  bool
+		allHTML     bool
+		strictHTML  bool
+		inputWord   bool
+		nestedTags  bool
+		clozeCount  bool
+		clozeNums   bool
+		selfDefine  bool
+		wholeCloze  bool
+		ruby        bool
+		answers     bool
+		swapped     bool
+		reading     bool
+		tagOrder    bool
+		phrases     bool
+		allowPhrase bool
+		sortTags    bool
+		links       struct {
+			check       bool
+			timeout     time.Duration
+			concurrency int
+		}
+		anki struct {
+			directives bool
+			AnkiDirectives
+		}
+		format      string
+		layout      string
+		hashtags    bool
+		lowerTags   bool
+		tagSep      string
+		timing      bool
+		bom         bool
+		checksum    bool
+		crlf        bool
+		emitLine    bool
+		splitClozes bool
+		placeholder string
+		escapeNL    bool
+		glob        string
+		guid        bool
+		strictDelim bool
+		collapse    bool
+		stripInvis  bool
+		nfc         bool
+		htmlDepth   int
+		normalize   bool
+		limit       int
+		maxErrors   int
+		shuffle     bool
+		seed        int64
+		splitByTag  string
+		tagsOut     string
+		noTagColumn bool
+		bundle      string
+		excludeFile string
+		freqFile    string
+		sort        string
+		tagVocab    string
+		foldVocab   bool
+		plain       string
+		recordSep   string
+		commentSep  string
+		quizlet     struct {
+			term, definition, separator string
+		}
+	}{}
+	flags := flag.NewFlagSet("jyuuyou2200", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&cli.prefix, "p", "JLPT-N2-JY-2200", "prefix for card IDs and media files")
+	flags.BoolVar(&cli.prefixFile, "prefix-from-filename", false, "append each input file's base name to the prefix")
+	flags.Int64Var(&cli.idBase, "id-base", 0, "offset added to entry IDs in card IDs and audio file names")
+	flags.StringVar(&cli.glob, "glob", "", "read every input file matching the pattern instead of a single input file")
+	flags.IntVar(&cli.maxLineSize, "max-line-size", DefaultMaxLineSize, "maximum length in bytes of a single input line")
+	flags.StringVar(&cli.gitSince, "git-since", "", "git revision; only entries changed since the input files at that revision are written")
+	flags.StringVar(&cli.since, "since", "", "previously generated output; only new or changed rows are written")
+	flags.BoolVar(&cli.stats, "stats", false, "print field completeness statistics")
+	flags.BoolVar(&cli.timing, "timing", false, "print parse and write durations to stderr")
+	flags.StringVar(&cli.defaultTags, "default-tags", "", "comma or space separated tags added to every entry")
+	flags.BoolVar(&cli.lowerTags, "lower-tags", false, "lowercase all tags in the output")
+	flags.BoolVar(&cli.sortTags, "sort-tags", false, "sort the tags of each entry in the output")
+	flags.StringVar(&cli.tagSep, "tag-hierarchy-sep", "", "separator in tags rewritten to \"::\" so they nest in Anki")
+	flags.StringVar(&cli.mtimeFile, "mtime-file", "", "file of id<TAB>timestamp rows, with RFC 3339 timestamps, written as an extra column")
+	flags.StringVar(&cli.tagsFile, "tags-file", "", "file of id<TAB>tag1,tag2 rows merged into entry tags")
+	flags.BoolVar(&cli.shuffle, "shuffle", false, "write the entries in a pseudo-random order determined by -seed")
+	flags.Int64Var(&cli.seed, "seed", 1, "seed for the order of -shuffle")
+	flags.StringVar(&cli.bundle, "bundle", "", "also write the output and a media manifest.txt into this tar archive")
+	flags.StringVar(&cli.splitByTag, "split-by-tag", "", "also write one file per tag into the directory")
+	flags.StringVar(&cli.tagsOut, "tags-out", "", "also write the card ID and tags of each entry to the file as id<TAB>tags rows")
+	flags.BoolVar(&cli.noTagColumn, "no-tag-column", false, "leave the tags column out of the output, such as when using -tags-out")
+	flags.IntVar(&cli.maxErrors, "max-errors", 0, "stop parsing after this many errors; 0 means no limit")
+	flags.IntVar(&cli.limit, "limit", 0, "maximum number of entries written; 0 means no limit")
+	flags.BoolVar(&cli.guid, "guid", false, "add a leading column with a stable note GUID")
+	flags.StringVar(&cli.placeholder, "empty-placeholder", "", "text written in place of empty csv fields, such as &nbsp;")
+	flags.BoolVar(&cli.splitClozes, "split-clozes", false, "write a row per cloze number, keeping only that cloze in each")
+	flags.BoolVar(&cli.emitLine, "emit-line", false, "append a column with the source line number of each entry")
+	flags.BoolVar(&cli.crlf, "crlf", false, "end csv rows with CRLF instead of LF")
+	flags.BoolVar(&cli.escapeNL, "escape-newlines", false, "replace newlines within csv fields with <br> instead of quoting them")
+	flags.BoolVar(&cli.checksum, "checksum", false, "also write the SHA-256 of the output file to <output>.sha256")
+	flags.BoolVar(&cli.bom, "bom", false, "prefix the output with a UTF-8 byte order mark")
+	flags.StringVar(&cli.layout, "layout", strings.Join(DefaultLayout, ","), "comma separated names of the fields following each ID line")
+	flags.BoolVar(&cli.collapse, "collapse-space", false, "replace runs of whitespace in fields, including full-width spaces, with a single space")
+	flags.BoolVar(&cli.stripInvis, "strip-invisible", false, "remove zero-width spaces, word joiners and byte order marks from fields instead of marking the entry dirty")
+	flags.BoolVar(&cli.strictDelim, "strict-delimiter", false, "require delimiter lines to match exactly, without trimming whitespace")
+	flags.StringVar(&cli.recordSep, "record-sep", EntryDelimiter, "line separating entries in the input file")
+	flags.StringVar(&cli.commentSep, "comment-prefix", EntryCommentPrefix, "text between the dirty marker slot and the comment of ID lines, such as \" // \"")
+	flags.StringVar(&cli.tagVocab, "tag-vocab", "", "file of allowed tags, one per line; entries with other tags are dirty")
+	flags.BoolVar(&cli.foldVocab, "tag-vocab-fold", false, "ignore case when checking tags against -tag-vocab")
+	flags.StringVar(&cli.freqFile, "freq-file", "", "file of word<TAB>rank rows written as an extra rank column")
+	flags.StringVar(&cli.sort, "sort", SortID, "order of the written entries: id or freq")
+	flags.StringVar(&cli.excludeFile, "exclude-file", "", "file of words, one per line, whose entries are not written")
+	flags.StringVar(&cli.plain, "plain", "", "comma separated fields holding plain text, escaped on output instead of validated as HTML")
+	flags.BoolVar(&cli.hashtags, "hashtags", false, "move #tag words from ID line comments into the tags")
+	flags.StringVar(&cli.format, "format", FormatCSV, "output format: csv, listening, basic, quizlet, txt, jsonl or index")
+	flags.BoolVar(&cli.anki.directives, "anki-directives", false, "start the output with #directive lines configuring Anki's import")
+	flags.StringVar(&cli.anki.Separator, "anki-separator", "Tab", "separator named by -anki-directives")
+	flags.BoolVar(&cli.anki.HTML, "anki-html", true, "whether -anki-directives declares the fields as HTML")
+	flags.StringVar(&cli.anki.Notetype, "anki-notetype", "", "note type named by -anki-directives")
+	flags.IntVar(&cli.anki.TagsColumn, "anki-tags-column", 0, "tags column named by -anki-directives, counted from 1; 0 uses the column of the format")
+	flags.StringVar(&cli.quizlet.term, "quizlet-term", "word", "field used as the quizlet term")
+	flags.StringVar(&cli.quizlet.definition, "quizlet-definition", "definition", "field used as the quizlet definition")
+	flags.StringVar(&cli.quizlet.separator, "quizlet-row-sep", `\n`, "separator between quizlet rows; Go escapes are interpreted")
+	flags.BoolVar(&cli.structOnly, "structure-only", false, "only check the IDs, fields and delimiters, skipping all validations")
+	flags.StringVar(&cli.profile, "profile", "standard", "preset of checks: lenient (no HTML checks), standard or strict (all offline checks)")
+	flags.BoolVar(&cli.identical, "check-identical", false, "mark entries dirty when usage and translation are identical")
+	flags.BoolVar(&cli.entities, "check-entities", false, "mark entries dirty when they contain unknown HTML entities")
+	flags.BoolVar(&cli.allHTML, "html-all-fields", false, "also validate the HTML of the word, pronunciation and definition")
+	flags.BoolVar(&cli.strictHTML, "strict-html", false, "fail on malformed HTML instead of marking the entry dirty")
+	flags.BoolVar(&cli.inputWord, "check-input-word", false, "warn when the cloze answer differs from the word")
+	flags.BoolVar(&cli.nestedTags, "check-nested-tags", false, "warn when a tag is opened directly inside an identical tag")
+	flags.BoolVar(&cli.clozeCount, "check-cloze-count", false, "warn when the usage and translation have a different number of cloze deletions")
+	flags.BoolVar(&cli.clozeNums, "check-cloze-numbers", false, "warn when the usage and translation use different cloze numbers")
+	flags.BoolVar(&cli.selfDefine, "check-self-define", false, "warn when the definition only repeats the word")
+	flags.BoolVar(&cli.wholeCloze, "check-whole-cloze", false, "warn when a single cloze covers the whole translation")
+	flags.BoolVar(&cli.ruby, "check-ruby", false, "warn when the <ruby> reading of the word differs from the pronunciation")
+	flags.BoolVar(&cli.answers, "check-cloze-answers", false, "warn when two clozes in a field hide the same answer")
+	flags.BoolVar(&cli.swapped, "check-swapped", false, "warn when the translation looks more Japanese than the usage")
+	flags.BoolVar(&cli.tagOrder, "check-tag-order", false, "warn when the tags of an entry are not sorted")
+	flags.BoolVar(&cli.phrases, "check-phrases", false, "warn when the word contains a space")
+	flags.BoolVar(&cli.allowPhrase, "allow-phrases", false, "turn off -check-phrases, including in the strict profile, for decks of phrases")
+	flags.BoolVar(&cli.reading, "check-reading", false, "warn when the pronunciation repeats a word containing kanji")
+	flags.BoolVar(&cli.nfc, "validate-unicode-nfc", false, "mark entries dirty when fields are not in Unicode normalization form C")
+	flags.BoolVar(&cli.normalize, "normalize-nfc", false, "convert fields to Unicode normalization form C")
+	flags.IntVar(&cli.htmlDepth, "max-html-depth", 0, "warn when tags in a field are nested more than this many levels deep; 0 means no limit")
+	flags.BoolVar(&cli.links.check, "check-links", false, "warn about URLs in ID line comments that fail a HEAD request")
+	flags.DurationVar(&cli.links.timeout, "link-timeout", DefaultLinkTimeout, "time allowed for each link checked by -check-links")
+	flags.IntVar(&cli.links.concurrency, "link-concurrency", 4, "maximum number of links checked at once by -check-links")
+	flags.BoolVar(&cli.lint, "lint", false, "only report dirty entries; no output file is written")
+	flags.StringVar(&cli.diff, "diff", "", "print the cards added, changed or removed since this previously generated output file; no output file is written")
+	flags.BoolVar(&cli.autoID, "auto-id", false, "assign free IDs to entries with a \""+EntryIDPlaceholder+"\" ID line, then rewrite the input file")
+	flags.BoolVar(&cli.renumber, "renumber", false, "reassign entry IDs from 1 without gaps, then rewrite the ID lines of the input file; audio files must be renamed and Anki imports moved entries as new notes")
+	flags.BoolVar(&cli.fix, "fix", false, "correct whitespace, tags and unnumbered clozes, then rewrite the input file")
+	flags.BoolVar(&cli.count, "count", false, "only count the entries that would be generated; no output file is written")
+	flags.BoolVar(&cli.showFields, "show-fields", false, "print the usage and translation of dirty entries in the report")
+	flags.StringVar(&cli.reportFmt, "report-format", "list", "format of the dirty entry report: list or table")
+	flags.StringVar(&cli.reportSort, "report-sort", "id", "order of the table report: id or reason")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	profile, ok := Profiles[cli.profile]
+	if !ok {
+		return fmt.Errorf("invalid profile: %q: expected lenient, standard or strict", cli.profile)
+	}
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for _, name := range profile.Checks {
+		if !explicit[name] {
+			if err := flags.Set(name, "true"); err != nil {
+				return err
+			}
+		}
+	}
+	if err := ValidatePrefix(cli.prefix); err != nil {
+		return err
+	}
+	if cli.reportFmt != "list" && cli.reportFmt != "table" {
+		return fmt.Errorf("invalid report format: %q: expected list or table", cli.reportFmt)
+	}
+	if cli.reportSort != "id" && cli.reportSort != "reason" {
+		return fmt.Errorf("invalid report order: %q: expected id or reason", cli.reportSort)
+	}
+	if cli.idBase < 0 {
+		return fmt.Errorf("invalid ID base: %d: must not be negative", cli.idBase)
+	}
+	if cli.commentSep == "" {
+		return errors.New("invalid comment prefix: must not be empty")
+	}
+	if strings.TrimSpace(cli.recordSep) == "" {
+		return fmt.Errorf("invalid record separator: %q: must not be empty", cli.recordSep)
+	}
+	inputs, positional := []string{flags.Arg(0)}, flags.Args()
+	if cli.glob != "" {
+		matches, err := filepath.Glob(cli.glob)
+		if err != nil {
+			return fmt.Errorf("invalid glob pattern: %q: %v", cli.glob, err)
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("no input files match: %q", cli.glob)
+		}
+		sort.Strings(matches)
+		inputs = matches
+	} else if len(positional) > 0 {
+		positional = positional[1:]
+	}
+	if (cli.fix || cli.autoID || cli.renumber) && cli.glob != "" {
+		return errors.New("-fix, -auto-id and -renumber rewrite a single input file and cannot be used with -glob")
+	}
+	if len(positional) != 1 && !((cli.lint || cli.fix || cli.renumber || cli.count || cli.diff != "" || cli.bundle != "" || cli.splitByTag != "") && len(positional) == 0) {
+		if cli.glob != "" {
+			return fmt.Errorf("invalid number of arguments: usage: %s -glob pattern output.csv", flags.Name())
+		}
+		return fmt.Errorf("invalid number of arguments: usage: %s input.txt output.csv", flags.Name())
+	}
+	output := ""
+	if len(positional) == 1 {
+		output = positional[0]
+	}
+	if cli.noTagColumn && cli.diff != "" {
+		return errors.New("-no-tag-column cannot be used with -diff")
+	}
+	if cli.checksum && output == "" {
+		return errors.New("-checksum requires an output file")
+	}
+	validators := baseValidators(cli.format != FormatBasic, profile.HTML)
+	if cli.identical {
+		validators = append(validators, IdenticalValidator)
+	}
+	if cli.allHTML {
+		validators = append(validators, ExtraHTMLValidator)
+	}
+	if cli.strictHTML {
+		validators = append(validators, Fatal(HTMLValidator))
+		if cli.allHTML {
+			validators = append(validators, Fatal(ExtraHTMLValidator))
+		}
+	}
+	if cli.entities {
+		validators = append(validators, EntityValidator)
+	}
+	if cli.inputWord {
+		validators = append(validators, InputWordValidator)
+	}
+	if cli.nestedTags {
+		validators = append(validators, NestedTagValidator)
+	}
+	if cli.clozeCount {
+		validators = append(validators, ClozeCountValidator)
+	}
+	if cli.clozeNums {
+		validators = append(validators, ClozeNumbersValidator)
+	}
+	if cli.selfDefine {
+		validators = append(validators, SelfDefineValidator)
+	}
+	if cli.wholeCloze {
+		validators = append(validators, WholeClozeValidator)
+	}
+	if cli.ruby {
+		validators = append(validators, RubyValidator)
+	}
+	if cli.answers {
+		validators = append(validators, ClozeAnswersValidator)
+	}
+	if cli.swapped {
+		validators = append(validators, SwappedValidator)
+	}
+	if cli.reading {
+		validators = append(validators, ReadingValidator)
+	}
+	if cli.tagOrder {
+		validators = append(validators, TagOrderValidator)
+	}
+	if cli.phrases && !cli.allowPhrase {
+		validators = append(validators, PhraseValidator)
+	}
+	if cli.nfc {
+		validators = append(validators, NFCValidator)
+	}
+	if cli.htmlDepth > 0 {
+		validators = append(validators, MaxHTMLDepthValidator(cli.htmlDepth))
+	}
+	if cli.tagVocab != "" {
+		v, err := os.Open(cli.tagVocab)
+		if err != nil {
+			return fmt.Errorf("failed to open tag vocabulary file: %s: %v", cli.tagVocab, err)
+		}
+		vocabulary, err := NewTagVocabularyFromFile(v)
+		v.Close()
+		if err != nil {
+			return fmt.Errorf("failed to process tag vocabulary file: %v", err)
+		}
+		validators = append(validators, TagVocabularyValidator(vocabulary, cli.foldVocab))
+	}
+	if cli.structOnly {
+		// Only the IDs, fields and delimiters are checked by the parser
+		// itself.
+		validators = []Validator{}
+	}
+	// -max-errors 0 means no limit, but ParseOptions.MaxErrors stops at the
+	// first error when 0 and only has no limit when negative.
+	maxErrors := cli.maxErrors
+	if maxErrors <= 0 {
+		maxErrors = -1
+	}
+	start := time.Now()
+	parseOpts := ParseOptions{
+		MaxErrors:       maxErrors,
+		MaxLineSize:     cli.maxLineSize,
+		Validators:      validators,
+		Layout:          strings.Split(cli.layout, ","),
+		Hashtags:        cli.hashtags,
+		StrictDelimiter: cli.strictDelim,
+		CollapseSpace:   cli.collapse,
+		StripInvisible:  cli.stripInvis,
+		NormalizeNFC:    cli.normalize,
+		RecordSeparator: cli.recordSep,
+		CommentPrefix:   cli.commentSep,
+		AutoID:          cli.autoID,
+		PlainFields:     strings.FieldsFunc(cli.plain, func(r rune) bool { return r == ',' }),
+	}
+	entries, err := NewEntriesFromFiles(inputs, parseOpts)
+	if err != nil {
+		return err
+	}
+	report := func() int {
+		if cli.reportFmt == "table" {
+			return writeReportTable(stdout, entries, cli.reportSort == "reason")
+		}
+		return writeReport(stdout, entries, cli.showFields)
+	}
+	if cli.timing {
+		fmt.Fprintf(stderr, "timing: parse: %v\n", time.Since(start))
+	}
+	if cli.autoID {
+		assigned := 0
+		entries.Each(func(e Entry) {
+			if e.autoID {
+				assigned++
+			}
+		})
+		if assigned != 0 {
+			err := patchSource(inputs[0], func(lines []string) []string {
+				entries.patchIDs(inputs[0], lines)
+				return lines
+			})
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(stdout, "assigned %d entry IDs.\n", assigned)
+	}
+	if cli.renumber {
+		media := WriteOptions{Prefix: cli.prefix, PrefixFromFilename: cli.prefixFile, IDBase: cli.idBase}
+		before := make([]Entry, 0)
+		entries.Each(func(e Entry) { before = append(before, e) })
+		entries = entries.Renumber()
+		moved := 0
+		for index, entry := range before {
+			if entry.ID() == entries[index].ID() {
+				continue
+			}
+			moved++
+			fmt.Fprintf(stdout, "warning: %04d is now %04d: rename %s to %s\n", entry.ID(), entries[index].ID(), entry.cardAudioFile(media), entries[index].cardAudioFile(media))
+		}
+		if moved != 0 {
+			fmt.Fprintln(stdout, "warning: card IDs and -guid note GUIDs follow the entry IDs, so Anki imports renumbered entries as new notes without their review history")
+			err := patchSource(inputs[0], func(lines []string) []string {
+				entries.patchIDs(inputs[0], lines)
+				return lines
+			})
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(stdout, "renumbered %d entries.\n", moved)
+	}
+	if cli.fix {
+		// The fixes are made before -tags-file and -mtime-file merge data
+		// from elsewhere into the entries, and only the source lines they
+		// change are rewritten.
+		counts := entries.Fix(validators)
+		for _, path := range inputs {
+			err := patchSource(path, func(lines []string) []string {
+				if n := entries.FixSource(path, lines); n != 0 {
+					counts[FixLineEndings] += n
+				}
+				return lines
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if len(counts) == 0 {
+			fmt.Fprintln(stdout, "found nothing to fix.")
+		}
+		for _, kind := range []string{FixWhitespace, FixTags, FixCloze, FixLineEndings} {
+			if counts[kind] != 0 {
+				fmt.Fprintf(stdout, "fixed %s in %d entries.\n", kind, counts[kind])
+			}
+		}
+	}
+	if cli.links.check {
+		entries.CheckLinks(LinkOptions{Timeout: cli.links.timeout, Concurrency: cli.links.concurrency})
+	}
+	// mergeFiles merges -tags-file and -mtime-file into e, printing their
+	// warnings to w.
+	mergeFiles := func(e *Entries, w io.Writer) error {
+		if cli.tagsFile != "" {
+			t, err := os.Open(cli.tagsFile)
+			if err != nil {
+				return fmt.Errorf("failed to open tags file: %s: %v", cli.tagsFile, err)
+			}
+			warnings, err := e.MergeTagsFromFile(t)
+			t.Close()
+			if err != nil {
+				return fmt.Errorf("failed to process tags file: %v", err)
+			}
+			for _, warning := range warnings {
+				fmt.Fprintln(w, "warning:", cli.tagsFile+":", warning)
+			}
+		}
+		if cli.mtimeFile != "" {
+			m, err := os.Open(cli.mtimeFile)
+			if err != nil {
+				return fmt.Errorf("failed to open mtime file: %s: %v", cli.mtimeFile, err)
+			}
+			warnings, err := e.MergeModifiedFromFile(m)
+			m.Close()
+			if err != nil {
+				return fmt.Errorf("failed to process mtime file: %v", err)
+			}
+			for _, warning := range warnings {
+				fmt.Fprintln(w, "warning:", cli.mtimeFile+":", warning)
+			}
+		}
+		return nil
+	}
+	if err := mergeFiles(&entries, stdout); err != nil {
+		return err
+	}
+	if cli.lint || (cli.fix && output == "") {
+		if report() == 0 {
+			fmt.Fprintln(stdout, "found no dirty entries.")
+		}
+		writeWarnings(stdout, entries)
+		return nil
+	}
+	var baseline map[string][]string
+	if cli.since != "" {
+		b, err := os.Open(cli.since)
+		if err != nil {
+			return fmt.Errorf("failed to open baseline file: %s: %v", cli.since, err)
+		}
+		baseline, err = NewBaselineFromFile(b)
+		b.Close()
+		if err != nil {
+			return fmt.Errorf("failed to process baseline file: %v", err)
+		}
+	}
+	var previous *Entries
+	if cli.gitSince != "" {
+		old, err := NewEntriesFromSources(inputs, parseOpts, gitContent(cli.gitSince))
+		if err != nil {
+			return err
+		}
+		if err := mergeFiles(&old, io.Discard); err != nil {
+			return err
+		}
+		previous = &old
+	}
+	if cli.sort != SortID && cli.sort != SortFrequency {
+		return fmt.Errorf("invalid sort order: %q: expected %s or %s", cli.sort, SortID, SortFrequency)
+	}
+	if cli.sort == SortFrequency && cli.freqFile == "" {
+		return fmt.Errorf("-sort %s requires -freq-file", SortFrequency)
+	}
+	var frequencies map[string]int
+	if cli.freqFile != "" {
+		r, err := os.Open(cli.freqFile)
+		if err != nil {
+			return fmt.Errorf("failed to open frequency file: %s: %v", cli.freqFile, err)
+		}
+		frequencies, err = NewFrequenciesFromFile(r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("failed to process frequency file: %v", err)
+		}
+	}
+	var exclude map[string]bool
+	if cli.excludeFile != "" {
+		x, err := os.Open(cli.excludeFile)
+		if err != nil {
+			return fmt.Errorf("failed to open exclude file: %s: %v", cli.excludeFile, err)
+		}
+		exclude, err = NewExcludeFromFile(x)
+		x.Close()
+		if err != nil {
+			return fmt.Errorf("failed to process exclude file: %v", err)
+		}
+	}
+	quizletSeparator, err := strconv.Unquote(`"` + cli.quizlet.separator + `"`)
+	if err != nil {
+		return fmt.Errorf("invalid quizlet row separator: %q: %v", cli.quizlet.separator, err)
+	}
+	var directives *AnkiDirectives
+	if cli.anki.directives {
+		if cli.format != FormatCSV && cli.format != FormatListening && cli.format != FormatBasic {
+			return fmt.Errorf("-anki-directives requires the %s, %s or %s format", FormatCSV, FormatListening, FormatBasic)
+		}
+		if cli.anki.TagsColumn < 0 {
+			return fmt.Errorf("invalid tags column: %d: must not be negative", cli.anki.TagsColumn)
+		}
+		directives = &cli.anki.AnkiDirectives
+	}
+	opts := WriteOptions{
+		Prefix:                cli.prefix,
+		PrefixFromFilename:    cli.prefixFile,
+		IDBase:                cli.idBase,
+		Format:                cli.format,
+		Baseline:              baseline,
+		Previous:              previous,
+		Exclude:               exclude,
+		Frequencies:           frequencies,
+		Sort:                  cli.sort,
+		LowerTags:             cli.lowerTags,
+		SortTags:              cli.sortTags,
+		TagHierarchySeparator: cli.tagSep,
+		BOM:                   cli.bom,
+		CRLF:                  cli.crlf,
+		EmitLine:              cli.emitLine,
+		SplitClozes:           cli.splitClozes,
+		NoTagColumn:           cli.noTagColumn,
+		Directives:            directives,
+		EmptyPlaceholder:      cli.placeholder,
+		EscapeNewlines:        cli.escapeNL,
+		GUID:                  cli.guid,
+		Modified:              cli.mtimeFile != "",
+		Limit:                 cli.limit,
+		Shuffle:               cli.shuffle,
+		Seed:                  cli.seed,
+		QuizletTerm:           cli.quizlet.term,
+		QuizletDefinition:     cli.quizlet.definition,
+		QuizletRowSeparator:   quizletSeparator,
+		DefaultTags: strings.FieldsFunc(cli.defaultTags, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		}),
+	}
+	if cli.diff != "" {
+		d, err := os.Open(cli.diff)
+		if err != nil {
+			return fmt.Errorf("failed to open diff file: %s: %v", cli.diff, err)
+		}
+		previous, err := NewBaselineFromFile(d)
+		d.Close()
+		if err != nil {
+			return fmt.Errorf("failed to process diff file: %v", err)
+		}
+		writeDiff(stdout, entries.Diff(previous, opts))
+		return nil
+	}
+	if cli.count {
+		count, dirty, err := entries.Write(io.Discard, opts)
+		if err != nil {
+			return fmt.Errorf("failed to count entries: %v", err)
+		}
+		fmt.Fprintf(stdout, "generated %d entries. (dirty %d)\n", count, dirty)
+		return nil
+	}
+	start = time.Now()
+	count := 0
+	if output != "" {
+		w, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("failed to open output file: %s: %v", output, err)
+		}
+		defer w.Close()
+		hash := sha256.New()
+		if count, _, err = entries.Write(io.MultiWriter(w, hash), opts); err != nil {
+			return fmt.Errorf("failed to write output file: %v", err)
+		}
+		if cli.checksum {
+			sum := fmt.Sprintf("%x  %s\n", hash.Sum(nil), filepath.Base(output))
+			if err := os.WriteFile(output+".sha256", []byte(sum), 0644); err != nil {
+				return fmt.Errorf("failed to write checksum file: %v", err)
+			}
+		}
+	}
+	if cli.bundle != "" {
+		b, err := os.Create(cli.bundle)
+		if err != nil {
+			return fmt.Errorf("failed to open bundle file: %s: %v", cli.bundle, err)
+		}
+		n, err := entries.WriteBundle(b, strings.TrimSuffix(filepath.Base(cli.bundle), ".tar"), opts)
+		if cerr := b.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("failed to write bundle file: %s: %v", cli.bundle, cerr)
+		}
+		if err != nil {
+			return err
+		}
+		if output == "" {
+			count = n
+		}
+	}
+	if cli.tagsOut != "" {
+		t, err := os.Create(cli.tagsOut)
+		if err != nil {
+			return fmt.Errorf("failed to open tags file: %s: %v", cli.tagsOut, err)
+		}
+		_, err = entries.WriteTags(t, opts)
+		if cerr := t.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("failed to write tags file: %s: %v", cli.tagsOut, cerr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	var files map[string]int
+	if cli.splitByTag != "" {
+		if files, err = entries.WriteByTag(DirSink(cli.splitByTag), opts); err != nil {
+			return err
+		}
+	}
+	if cli.timing {
+		fmt.Fprintf(stderr, "timing: write: %v\n", time.Since(start))
+	}
+	report()
+	writeWarnings(stdout, entries)
+	if output != "" || cli.bundle != "" {
+		fmt.Fprintln(stdout, "generated", count, "entries.")
+	}
+	if cli.splitByTag != "" {
+		fmt.Fprintln(stdout, "generated", len(files), "tag files in", cli.splitByTag+".")
+	}
+	if cli.stats {
+		completeness := entries.Completeness()
+		names := make([]string, 0, len(completeness))
+		for name := range completeness {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprint(stdout, "\nfield completeness:\n")
+		for _, name := range names {
+			fmt.Fprintf(stdout, "  %s %6.2f%%\n", PadRight(name, 13), completeness[name]*100)
+		}
+	}
+	return nil
+}
+
+// patchSource replaces the lines of the file at path with those returned by
+// edit, which is given the lines of the file split at each line feed. A UTF-8
+// byte order mark is kept and the file is left alone when nothing changed.
+func patchSource(path string, edit func(lines []string) []string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat source file: %s: %v", path, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read source file: %s: %v", path, err)
+	}
+	if strings.HasPrefix(string(data), "\xff\xfe") || strings.HasPrefix(string(data), "\xfe\xff") {
+		return fmt.Errorf("failed to rewrite source file: %s: UTF-16 files cannot be rewritten", path)
+	}
+	bom, text := "", string(data)
+	if strings.HasPrefix(text, "\ufeff") {
+		bom, text = "\ufeff", strings.TrimPrefix(text, "\ufeff")
+	}
+	patched := bom + strings.Join(edit(strings.Split(text, "\n")), "\n")
+	if patched == string(data) {
+		return nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(patched); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write source file: %s: %v", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write source file: %s: %v", path, err)
+	}
+	if err := os.Chmod(f.Name(), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write source file: %s: %v", path, err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace source file: %s: %v", path, err)
+	}
+	return nil
+}
+
+// patchIDs sets the digits of the ID line of each entry read from file to the
+// entry's ID, keeping the rest of the line. lines are the lines of the file
+// as given by patchSource.
+func (entries *Entries) patchIDs(file string, lines []string) {
+	entries.Each(func(e Entry) {
+		if e.file == file && e.line > 0 {
+			lines[e.line-1] = fmt.Sprintf("%04d", e.id) + lines[e.line-1][digitsOffset+1:]
+		}
+	})
+}
+
+// ValidatePrefix ensures the prefix can be used in a flat media filename.
+func ValidatePrefix(prefix string) error {
+	if prefix == "" {
+		return errors.New("invalid prefix: prefix is empty")
+	}
+	for _, r := range prefix {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|[]`, r) {
+			return fmt.Errorf("invalid prefix: %q: contains unsafe character %q", prefix, r)
+		}
+	}
+	return nil
+}
+
+// writeReport prints each dirty entry, labelled with whether it was marked by
+// hand or by validation, along with its comments and returns the number of
+// dirty entries. When showFields is set, the usage and translation
+// of each dirty entry are printed beneath its comments.
+func writeReport(w io.Writer, entries Entries, showFields bool) int {
+	dirty := 0
+	for _, entry := range entries {
+		if entry.IsDirty() {
+			dirty++
+		}
+	}
+	if dirty == 0 {
+		return 0
+	}
+	fmt.Fprintln(w, "found", dirty, "dirty entries.")
+	for _, entry := range entries {
+		if !entry.IsDirty() {
+			continue
+		}
+		fmt.Fprint(w, "\n")
+		prefix := fmt.Sprintf("  %04d: %s ", entry.ID(), dirtyLabel(entry))
+		indent := strings.Repeat(" ", DisplayWidth(prefix))
+		if len(entry.Comments()) == 0 {
+			fmt.Fprintf(w, "%smarked.\n", prefix)
+		}
+		for index, comment := range entry.Comments() {
+			if index == 0 {
+				fmt.Fprintf(w, "%s%s\n", prefix, comment)
+			} else {
+				fmt.Fprintf(w, "%s%s\n", indent, comment)
+			}
+		}
+		if showFields {
+			fmt.Fprintf(w, "%susage: %s\n", indent, entry.Usage())
+			fmt.Fprintf(w, "%stranslation: %s\n", indent, entry.Translation())
+		}
+	}
+	fmt.Fprint(w, "\n")
+	return dirty
+}
+
+// writeReportTable prints the dirty entries like writeReport but as a table
+// with a row per comment, sorted by reason if byReason is set. It returns the
+// number of dirty entries.
+func writeReportTable(w io.Writer, entries Entries, byReason bool) int {
+	type reportRow struct{ id, word, field, reason string }
+	rows := make([]reportRow, 0)
+	dirty := 0
+	for _, entry := range entries {
+		if !entry.IsDirty() {
+			continue
+		}
+		dirty++
+		id, word := fmt.Sprintf("%04d", entry.ID()), plainText(entry.Word())
+		if len(entry.Comments()) == 0 {
+			rows = append(rows, reportRow{id, word, "-", "marked."})
+		}
+		for _, comment := range entry.Comments() {
+			field := "-"
+			for _, f := range entry.textFields() {
+				if strings.HasPrefix(comment, f.name+" ") || strings.HasPrefix(comment, f.name+":") {
+					field = f.name
+					break
+				}
+			}
+			rows = append(rows, reportRow{id, word, field, comment})
+		}
+	}
+	if dirty == 0 {
+		return 0
+	}
+	if byReason {
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i].reason < rows[j].reason })
+	}
+	widths := [3]int{DisplayWidth("ID"), DisplayWidth("word"), DisplayWidth("field")}
+	for _, row := range rows {
+		for index, value := range []string{row.id, row.word, row.field} {
+			if n := DisplayWidth(value); n > widths[index] {
+				widths[index] = n
+			}
+		}
+	}
+	fmt.Fprintln(w, "found", dirty, "dirty entries.")
+	fmt.Fprint(w, "\n")
+	rows = append([]reportRow{{"ID", "word", "field", "reason"}}, rows...)
+	for _, row := range rows {
+		fmt.Fprintf(w, "  %s  %s  %s  %s\n", PadRight(row.id, widths[0]), PadRight(row.word, widths[1]), PadRight(row.field, widths[2]), row.reason)
+	}
+	fmt.Fprint(w, "\n")
+	return dirty
+}
+
+// writeDiff prints the cards in diff, one per line.
+func writeDiff(w io.Writer, diff EntryDiff) {
+	if diff.Empty() {
+		fmt.Fprintln(w, "found no changes.")
+		return
+	}
+	for _, id := range diff.Added {
+		fmt.Fprintf(w, "  added:   %s\n", id)
+	}
+	for _, changed := range diff.Changed {
+		fmt.Fprintf(w, "  changed: %s (%s)\n", changed.ID, strings.Join(changed.Fields, ", "))
+	}
+	for _, id := range diff.Removed {
+		fmt.Fprintf(w, "  removed: %s\n", id)
+	}
+}
+
+// writeWarnings prints the warnings of each entry and returns the number of
+// entries with warnings.
+func writeWarnings(w io.Writer, entries Entries) int {
+	warned := 0
+	for _, entry := range entries {
+		if len(entry.Warnings()) != 0 {
+			warned++
+		}
+	}
+	if warned == 0 {
+		return 0
+	}
+	fmt.Fprintln(w, "found", warned, "entries with warnings.")
+	fmt.Fprint(w, "\n")
+	for _, entry := range entries {
+		if len(entry.Warnings()) == 0 {
+			continue
+		}
+		prefix := fmt.Sprintf("  %04d: [warning] ", entry.ID())
+		indent := strings.Repeat(" ", DisplayWidth(prefix))
+		for index, warning := range entry.Warnings() {
+			if index == 0 {
+				fmt.Fprintf(w, "%s%s\n", prefix, warning)
+			} else {
+				fmt.Fprintf(w, "%s%s\n", indent, warning)
+			}
+		}
+	}
+	fmt.Fprint(w, "\n")
+	return warned
+}
+
+// dirtyLabel describes whether the entry was marked dirty by hand, by
+// validation, or both.
+func dirtyLabel(entry Entry) string {
+	sources := make([]string, 0, 2)
+	if entry.IsMarked() {
+		sources = append(sources, "manual")
+	}
+	if entry.IsFlagged() {
+		sources = append(sources, "auto")
+	}
+	return "[" + strings.Join(sources, ", ") + "]"
+}
+
+func IsValidHTML(s string) error {
+	_, err := HTMLDepth(s)
+	return err
+}
+
+// voidElements are the HTML elements without a close tag, such as the <br>
+// joining continuation lines.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// HTMLDepth returns the deepest nesting of tags in s, checking the markup like
+// IsValidHTML. Void elements and self-closing tags do not nest.
+func HTMLDepth(s string) (int, error) {
+	tags := make([]string, 0)
+	depth := 0
+	for offset := 0; offset < len(s); {
+		start := strings.IndexByte(s[offset:], '<')
+		if start == -1 {
+			break
+		}
+		start += offset
+		if offset < start {
+			if strings.IndexByte(s[offset:start], '>') != -1 {
+				return depth, errors.New("found closing bracket before an opening bracket")
+			}
+			offset = start
+		}
+		end := strings.IndexByte(s[start:], '>')
+		if end == -1 {
+			return depth, errors.New("found opening bracket but no closing bracket")
+		}
+		end += offset
+		tag := strings.TrimSpace(s[start+1 : end])
+		selfClosing := strings.HasSuffix(tag, "/")
+		tag = strings.TrimSuffix(tag, "/")
+		if idx := strings.IndexByte(tag, ' '); idx >= 0 {
+			tag = tag[:idx]
+		}
+		if tag == "" {
+			return depth, errors.New("empty tag found")
+		}
+		if selfClosing || voidElements[strings.ToLower(tag)] {
+			if len(tags)+1 > depth {
+				depth = len(tags) + 1
+			}
+		} else if tag[0] == '/' {
+			if len(tags) == 0 {
+				return depth, fmt.Errorf("unexpected close tag found: %s", tag[1:])
+			}
+			if last, expected := tags[len(tags)-1], tag[1:]; last != expected {
+				return depth, fmt.Errorf("mismatched close tag found: %s != %s", last, expected)
+			}
+			tags = tags[:len(tags)-1]
+		} else {
+			tags = append(tags, tag)
+			if len(tags) > depth {
+				depth = len(tags)
+			}
+		}
+		offset = end + 1
+	}
+	if len(tags) != 0 {
+		return depth, fmt.Errorf("not all tags closed: %+v", tags)
+	}
+	return depth, nil
+}
+
+// CheckClozeBraces reports the first unbalanced curly brace in s. Positions
+// are counted in characters starting from 1.
+func CheckClozeBraces(s string) error {
+	depth, open, position := 0, 0, 0
+	for _, r := range s {
+		position++
+		switch r {
+		case '{':
+			if depth == 0 {
+				open = position
+			}
+			depth++
+		case '}':
+			if depth == 0 {
+				return fmt.Errorf("unbalanced cloze braces: unexpected \"}\" at character %d", position)
+			}
+			depth--
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("unbalanced cloze braces: unclosed \"{\" at character %d", open)
+	}
+	return nil
+}
+
+// StripHTML removes tags from s and unescapes any entities.
+func StripHTML(s string) string {
+	var b strings.Builder
+	for offset := 0; offset < len(s); {
+		start := strings.IndexByte(s[offset:], '<')
+		if start == -1 {
+			b.WriteString(s[offset:])
+			break
+		}
+		b.WriteString(s[offset : offset+start])
+		end := strings.IndexByte(s[offset+start:], '>')
+		if end == -1 {
+			b.WriteString(s[offset+start:])
+			break
+		}
+		offset += start + end + 1
+	}
+	return html.UnescapeString(b.String())
+}
+
+// IsInvisible reports whether r is a zero-width space, word joiner or byte
+// order mark, which are invisible but break matching text.
+func IsInvisible(r rune) bool {
+	return r == '\u200B' || r == '\u2060' || r == '\uFEFF'
+}
+
+// StripInvisible removes the characters reported by IsInvisible from s.
+func StripInvisible(s string) string {
+	return strings.Map(func(r rune) rune {
+		if IsInvisible(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// CollapseSpace replaces each run of whitespace in s, including ideographic
+// spaces, with a single ASCII space. Whitespace inside HTML tags is kept so
+// that attribute values are not changed.
+func CollapseSpace(s string) string {
+	var b strings.Builder
+	inTag, inSpace := false, false
+	for _, r := range s {
+		switch {
+		case inTag:
+			inTag = r != '>'
+		case unicode.IsSpace(r):
+			if !inSpace {
+				b.WriteByte(' ')
+			}
+			inSpace = true
+			continue
+		case r == '<':
+			inTag = true
+		}
+		inSpace = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// DisplayWidth returns the number of terminal cells needed to display s,
+// counting East Asian wide and fullwidth characters as two cells.
+func DisplayWidth(s string) int {
+	cells := 0
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.Mn, r) || unicode.IsControl(r):
+		case isWide(r):
+			cells += 2
+		default:
+			cells++
+		}
+	}
+	return cells
+}
+
+// PadRight pads s with spaces to the given display width.
+func PadRight(s string, width int) string {
+	if padding := width - DisplayWidth(s); padding > 0 {
+		return s + strings.Repeat(" ", padding)
+	}
+	return s
+}
+
+func isWide(r rune) bool {
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return true
+	}
+	return false
 }
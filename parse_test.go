@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const validEntry = `0001
+{{c1::食べる}}
+{{c1::eat}}
+食べる
+たべる
+to eat
+verb
+---
+`
+
+func TestLongLine(t *testing.T) {
+	input := strings.Replace(validEntry, "to eat", strings.Repeat("a", 100*1024), 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := len(entries[0].Definition()); got != 100*1024 {
+		t.Errorf("definition length: got %d, expected %d", got, 100*1024)
+	}
+	_, err = NewEntriesFromFileWithOptions(strings.NewReader(input), ParseOptions{MaxLineSize: 64 * 1024})
+	perr, ok := err.(EntriesParseError)
+	if !ok {
+		t.Fatalf("expected EntriesParseError, got %T: %v", err, err)
+	}
+	if perr.Line() != 6 {
+		t.Errorf("line: got %d, expected 6", perr.Line())
+	}
+	t.Log(perr)
+}
+
+func TestParseEntryBlock(t *testing.T) {
+	entry, errs := ParseEntryBlock(validEntry)
+	if len(errs) != 0 {
+		t.Errorf("valid block: unexpected errors: %v", errs)
+	}
+	if entry.ID() != 1 || entry.Input() != "食べる" || entry.Word() != "食べる" {
+		t.Errorf("valid block: unexpected entry: %+v", entry)
+	}
+	if _, errs := ParseEntryBlock(strings.TrimSuffix(validEntry, "---\n")); len(errs) != 0 {
+		t.Errorf("block without delimiter: unexpected errors: %v", errs)
+	}
+	for _, input := range []string{
+		strings.Replace(validEntry, "0001", "01", 1),
+		strings.Replace(validEntry, "0001", "abcd", 1),
+		strings.Replace(validEntry, "{{c1::食べる}}", "食べる", 1),
+		strings.Replace(validEntry, "{{c1::eat}}", "<b>{{c1::eat}}", 1),
+		strings.Replace(validEntry, "---", "--", 1),
+		strings.Replace(validEntry, "たべる\nto eat\n", "", 1),
+	} {
+		if _, errs := ParseEntryBlock(input); len(errs) != 0 {
+			t.Logf("%q: %v", input, errs)
+		} else {
+			t.Errorf("%q: this is invalid but no error returned!", input)
+		}
+	}
+}
+
+func TestCommentLines(t *testing.T) {
+	input := "# TODO: review\n" + validEntry + "  # second entry\n#\n" + strings.Replace(validEntry, "0001", "0002", 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for index := 0; index < 2; index++ {
+		if entries[index].ID() != int64(index+1) || entries[index].IsDirty() {
+			t.Errorf("%04d: unexpected entry: %+v", index+1, entries[index])
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	layout := []string{"usage", "translation", "word", "pronunciation", "definition", "example2", "tags"}
+	input := strings.Replace(validEntry, "to eat\n", "to eat\n毎日{{c1::食べる}}。\n", 1)
+	entries, err := NewEntriesFromFileWithOptions(strings.NewReader(input+strings.Replace(input, "0001", "0002", 1)), ParseOptions{Layout: layout})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for index := 0; index < 2; index++ {
+		entry := entries[index]
+		if example, ok := entry.Field("example2"); !ok || example != "毎日{{c1::食べる}}。" {
+			t.Errorf("%04d: example2: got %q", index+1, example)
+		}
+		if strings.Join(entry.Tags(), ",") != "verb" || entry.Definition() != "to eat" {
+			t.Errorf("%04d: unexpected entry: %+v", index+1, entry)
+		}
+		if row := entry.CSV(WriteOptions{Prefix: "TEST"}); row[len(row)-1] != "毎日{{c1::食べる}}。" {
+			t.Errorf("%04d: example2 missing from row: %q", index+1, row)
+		}
+		if got := strings.Join(entry.Source(), "\n") + "\n"; got != strings.Replace(input, "0001", fmt.Sprintf("%04d", index+1), 1) {
+			t.Errorf("%04d: source changed: %q", index+1, got)
+		}
+	}
+	if _, err := NewEntriesFromFileWithOptions(strings.NewReader(input), ParseOptions{Layout: []string{"usage", "usage"}}); err == nil {
+		t.Errorf("duplicate field names were accepted")
+	}
+}
+
+func TestContinuationLines(t *testing.T) {
+	input := strings.Replace(validEntry, "to eat\n", "to eat\\\nto have a meal\\\n(formal: 召し上がる)\n", 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input + strings.Replace(validEntry, "0001", "0002", 1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, expected := entries[0].Definition(), "to eat<br>to have a meal<br>(formal: 召し上がる)"; got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+	if strings.Join(entries[0].Tags(), ",") != "verb" || entries[1].ID() != 2 {
+		t.Errorf("continuation lines shifted the following fields: %+v", entries[:2])
+	}
+}
+
+func TestContinuationHTML(t *testing.T) {
+	input := strings.Replace(strings.Replace(validEntry,
+		"{{c1::食べる}}\n", "ご飯を\\\n{{c1::食べる}}\n", 1),
+		"to eat\n", "to eat\\\n<i>to have a meal</i>\n", 1)
+	_, path := writeInput(t, input)
+	var stdout bytes.Buffer
+	if err := run([]string{"-lint", "-html-all-fields", path}, &stdout, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "found no dirty entries.") {
+		t.Errorf("continued fields were marked dirty: %s", stdout.String())
+	}
+}
+
+func TestInvalidID(t *testing.T) {
+	for _, id := range []string{"0000", "0000* note", "9999", "-001"} {
+		_, err := NewEntriesFromFile(strings.NewReader(validEntry + strings.Replace(validEntry, "0001", id, 1)))
+		if perr, ok := err.(EntriesParseError); !ok || perr.Line() != 9 {
+			t.Errorf("%s: expected parse error on line 9, got %v", id, err)
+		} else {
+			t.Log(perr)
+		}
+	}
+}
+
+func TestParseStats(t *testing.T) {
+	data, err := os.ReadFile("testdata/entries.txt")
+	if err != nil {
+		t.Fatalf("failed to read input file: %v", err)
+	}
+	_, stats, err := NewEntriesFromFileWithStats(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := ParseStats{
+		Lines:   bytes.Count(data, []byte("\n")),
+		Bytes:   int64(len(data)),
+		Entries: 4,
+		Dirty:   1,
+	}
+	if stats != expected {
+		t.Errorf("got %+v, expected %+v", stats, expected)
+	}
+}
+
+func TestMissingFinalNewline(t *testing.T) {
+	input := validEntry + strings.Replace(validEntry, "0001", "0002", 1)
+	for _, input := range []string{strings.TrimSuffix(input, "\n"), strings.TrimSuffix(input, "\n") + "  "} {
+		entries, err := NewEntriesFromFile(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if entries[1].ID() != 2 || entries[1].IsDirty() {
+			t.Errorf("%q: last entry was not captured: %+v", input[len(input)-8:], entries[1])
+		}
+	}
+	for _, input := range []string{strings.TrimSuffix(input, "---\n"), strings.TrimSuffix(input, "verb\n---\n") + "verb\\"} {
+		_, err := NewEntriesFromFile(strings.NewReader(input))
+		if perr, ok := err.(EntriesParseError); !ok || perr.Line() != 15 {
+			t.Errorf("%q: expected parse error on line 15, got %v", input[len(input)-8:], err)
+		} else {
+			t.Log(perr)
+		}
+	}
+}
+
+func TestUTF16Input(t *testing.T) {
+	parse := func(path string) Entries {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read input file: %v", err)
+		}
+		entries, err := NewEntriesFromFile(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", path, err)
+		}
+		return entries
+	}
+	expected, entries := parse("testdata/entries.txt"), parse("testdata/entries-utf16le.txt")
+	for index := range entries {
+		if !entries[index].Equal(expected[index]) {
+			t.Errorf("%04d: got %+v, expected %+v", index+1, entries[index], expected[index])
+		}
+	}
+	if entries[0].ID() != 1 {
+		t.Errorf("no entries parsed from UTF-16 input")
+	}
+}
+
+func TestAutoID(t *testing.T) {
+	entry := func(id string) string { return strings.Replace(validEntry, "0001", id, 1) }
+	input := entry("0001") + entry("----") + entry("0003") + entry("----* check the reading") + entry("0002")
+	if _, err := NewEntriesFromFile(strings.NewReader(input)); err == nil {
+		t.Errorf("placeholder was accepted without AutoID")
+	}
+	entries, err := NewEntriesFromFileWithOptions(strings.NewReader(input), ParseOptions{AutoID: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entries[3].ID() != 4 || entries[4].ID() != 5 || !entries[4].IsMarked() || entries[4].Comment() != "check the reading" {
+		t.Errorf("placeholders were not assigned the free IDs: %+v, %+v", entries[3], entries[4])
+	}
+	var buf bytes.Buffer
+	if err := entries.WriteSource(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := entry("0001") + entry("0002") + entry("0003") + entry("0004") + entry("0005* check the reading"); buf.String() != expected {
+		t.Errorf("assigned IDs were not written:\n%s", buf.String())
+	}
+	if _, err := NewEntriesFromFileWithOptions(strings.NewReader(input+entry("0003")), ParseOptions{AutoID: true}); err == nil {
+		t.Errorf("duplicate ID was accepted")
+	}
+
+	// Only the placeholder ID lines change in the source file, so notes,
+	// continuation lines and the order of the entries are kept.
+	note := "# drafted from chapter 3\n"
+	continued := func(id string) string { return strings.Replace(entry(id), "to eat\n", "to eat\\\nto dine\n", 1) }
+	_, path := writeInput(t, note+entry("0001")+continued("----")+entry("0003")+note+entry("----* check the reading")+entry("0002"))
+	var stdout bytes.Buffer
+	if err := run([]string{"-auto-id", "-lint", path}, &stdout, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "assigned 2 entry IDs.") {
+		t.Errorf("unexpected output: %q", stdout.String())
+	}
+	expected := note + entry("0001") + continued("0004") + entry("0003") + note + entry("0005* check the reading") + entry("0002")
+	if source, err := os.ReadFile(path); err != nil || string(source) != expected {
+		t.Errorf("source file was not patched: %v:\n%s", err, source)
+	}
+}
+
+func TestMaxErrors(t *testing.T) {
+	input := validEntry
+	for id := 2; id <= 6; id++ {
+		input += strings.Replace(validEntry, "0001", fmt.Sprintf("%04d", id+9000), 1)
+	}
+	input += strings.Replace(validEntry, "0001", "0007", 1)
+	for _, test := range []struct {
+		max     int
+		errors  int
+		stopped bool
+	}{
+		{3, 3, true},
+		{5, 5, true},
+		{-1, 5, false},
+	} {
+		entries, err := NewEntriesFromFileWithOptions(strings.NewReader(input), ParseOptions{MaxErrors: test.max})
+		perrs, ok := err.(EntriesParseErrors)
+		if !ok || len(perrs.Errors) != test.errors || perrs.Stopped != test.stopped {
+			t.Errorf("%d: expected %d errors, got %v", test.max, test.errors, err)
+			continue
+		}
+		if stopped := strings.Contains(err.Error(), fmt.Sprintf("stopped after %d errors (more may exist)", test.errors)); stopped != test.stopped {
+			t.Errorf("%d: unexpected message: %v", test.max, err)
+		}
+		if perrs.Errors[1].Line() != 17 {
+			t.Errorf("%d: expected the second error on line 17, got %v", test.max, perrs.Errors[1])
+		}
+		if !test.stopped && (entries[0].ID() != 1 || entries[6].ID() != 7) {
+			t.Errorf("%d: valid entries around the errors were not parsed", test.max)
+		}
+	}
+	if _, err := NewEntriesFromFile(strings.NewReader(input)); err == nil {
+		t.Errorf("no error returned")
+	} else if _, ok := err.(EntriesParseError); !ok {
+		t.Errorf("expected a single parse error by default, got %v", err)
+	}
+
+	dir, path := writeInput(t, input)
+	err := run([]string{"-max-errors", "2", path, filepath.Join(dir, "output.csv")}, io.Discard, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "stopped after 2 errors (more may exist)") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestMissingDelimiter(t *testing.T) {
+	// 0001 is missing its delimiter, so the error is reported and 0002 is
+	// parsed from its ID line on, rather than skipped up to its delimiter.
+	input := strings.TrimSuffix(validEntry, "---\n") +
+		strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "{{c1::eat}}", "eat", 1) +
+		strings.Replace(validEntry, "0001", "0003", 1)
+	entries, err := NewEntriesFromFileWithOptions(strings.NewReader(input), ParseOptions{MaxErrors: -1})
+	perrs, ok := err.(EntriesParseErrors)
+	if !ok || len(perrs.Errors) != 1 || perrs.Errors[0].Line() != 8 {
+		t.Fatalf("expected an error on line 8, got %v", err)
+	}
+	if entries[0].ID() != 0 || entries[1].ID() != 2 || entries[2].ID() != 3 {
+		t.Errorf("unexpected entries: %04d, %04d, %04d", entries[0].ID(), entries[1].ID(), entries[2].ID())
+	}
+	if !entries[1].IsDirty() || entries[1].Line() != 8 {
+		t.Errorf("0002: problems were not found: %+v", entries[1])
+	}
+}
+
+func TestParseIDLine(t *testing.T) {
+	for _, test := range []struct {
+		data    string
+		id      int64
+		dirty   bool
+		comment string
+	}{
+		{"0001", 1, false, ""},
+		{"2200", 2200, false, ""},
+		{"0042*", 42, true, ""},
+		{"0042  recheck", 42, false, "recheck"},
+		{"0042* recheck the translation", 42, true, "recheck the translation"},
+	} {
+		id, dirty, comment, err := ParseIDLine(test.data)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.data, err)
+		} else if id != test.id || dirty != test.dirty || comment != test.comment {
+			t.Errorf("%q: got %d, %t, %q", test.data, id, dirty, comment)
+		}
+	}
+	for _, data := range []string{"", "001", "00a1", "0x01* note", "0000", "2201", "0001x foo", "0001*x foo", "00011"} {
+		if _, _, _, err := ParseIDLine(data); err != nil {
+			t.Logf("%q: %v", data, err)
+		} else {
+			t.Errorf("%q: this is invalid but no error returned!", data)
+		}
+	}
+}
+
+func TestDelimiterWhitespace(t *testing.T) {
+	input := strings.Replace(validEntry, "---", "--- ", 1) + strings.Replace(validEntry, "0001", "0002", 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entries[0].ID() != 1 || entries[1].ID() != 2 {
+		t.Errorf("entries missing: %+v", entries[:2])
+	}
+	_, err = NewEntriesFromFileWithOptions(strings.NewReader(input), ParseOptions{StrictDelimiter: true})
+	if perr, ok := err.(EntriesParseError); !ok || perr.Line() != 8 {
+		t.Errorf("expected parse error on line 8, got %v", err)
+	}
+}
+
+func TestDuplicateID(t *testing.T) {
+	for _, id := range []string{"0042", "+042", "0042* again"} {
+		input := strings.Replace(validEntry, "0001", "0042", 1) + strings.Replace(validEntry, "0001", id, 1)
+		_, err := NewEntriesFromFile(strings.NewReader(input))
+		perr, ok := err.(EntriesParseError)
+		if !ok || perr.Line() != 9 || !strings.Contains(perr.Error(), "on line 1") {
+			t.Errorf("%s: expected duplicate ID error naming lines 9 and 1, got %v", id, err)
+		} else {
+			t.Log(perr)
+		}
+	}
+}
+
+func TestShortIDCollision(t *testing.T) {
+	// IDs are always four digits, so "42" is rejected rather than
+	// normalized into the slot of "0042".
+	input := strings.Replace(validEntry, "0001", "0042", 1) + strings.Replace(strings.Replace(validEntry, "0001", "42", 1), "to eat", "to dine", 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if perr, ok := err.(EntriesParseError); !ok || perr.Line() != 9 {
+		t.Errorf("expected parse error on line 9, got %v", err)
+	}
+	if entries[41].ID() != 42 || entries[41].Definition() != "to eat" {
+		t.Errorf("0042 was overwritten: %+v", entries[41])
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDisplayWidth(t *testing.T) {
+	for input, expected := range map[string]int{
+		"abc":      3,
+		"食べる":      6,
+		"ｶﾀｶﾅ":     4,
+		"ＡＢ":       4,
+		"a、b":      4,
+		"[manual]": 8,
+	} {
+		if got := DisplayWidth(input); got != expected {
+			t.Errorf("%s: got %d, expected %d", input, got, expected)
+		}
+	}
+	if got, expected := PadRight("語", 4)+"|", "語  |"; got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestReportTableAlignment(t *testing.T) {
+	input := strings.Replace(strings.Replace(validEntry, "0001", "0001*", 1), "食べる\nたべる", "ｶﾀｶﾅ\nかたかな", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0002*", 1), "食べる\nたべる", "召し上がる\nめしあがる", 1) +
+		strings.Replace(validEntry, "0001", "0003*", 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	writeReportTable(&buf, entries, false)
+	lines := strings.Split(buf.String(), "\n")[2:6]
+	column := strings.Index(lines[0], "field")
+	for _, line := range lines[1:] {
+		index := strings.Index(line, "-")
+		if cells := DisplayWidth(line[:index]); index == -1 || cells != column {
+			t.Errorf("%q: field column at cell %d, expected %d", line, cells, column)
+		}
+	}
+}
+
+func TestReportAlignment(t *testing.T) {
+	entries, err := NewEntriesFromFile(strings.NewReader(
+		strings.Replace(strings.Replace(validEntry, "0001", "0001* 訳を確認する", 1), "{{c1::eat}}", "eat", 1),
+	))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	writeReport(&buf, entries, true)
+	lines := strings.Split(buf.String(), "\n")[2:6]
+	column := DisplayWidth(lines[0]) - DisplayWidth("訳を確認する")
+	for _, line := range lines[1:] {
+		if indent := len(line) - len(strings.TrimLeft(line, " ")); indent != column {
+			t.Errorf("%q: indented %d cells, expected %d", line, indent, column)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	rubyRegexp = regexp.MustCompile(`(?is)<ruby[^>]*>(.*?)</ruby>`)
+	rtRegexp   = regexp.MustCompile(`(?is)<rt[^>]*>(.*?)</rt>`)
+	rpRegexp   = regexp.MustCompile(`(?is)<rp[^>]*>.*?</rp>`)
+)
+
+// ParseRuby returns the base text of s and its reading, in which each <ruby>
+// element is replaced by the text of its <rt> annotations. Both are returned
+// without markup.
+func ParseRuby(s string) (base, reading string) {
+	s = rpRegexp.ReplaceAllString(s, "")
+	reading = rubyRegexp.ReplaceAllStringFunc(s, func(ruby string) string {
+		var b strings.Builder
+		for _, match := range rtRegexp.FindAllStringSubmatch(ruby, -1) {
+			b.WriteString(match[1])
+		}
+		return b.String()
+	})
+	base = rtRegexp.ReplaceAllString(s, "")
+	return StripHTML(base), StripHTML(reading)
+}
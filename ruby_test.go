@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRuby(t *testing.T) {
+	for input, expected := range map[string][2]string{
+		"食べる":                        {"食べる", "食べる"},
+		"<ruby>食<rt>た</rt></ruby>べる": {"食べる", "たべる"},
+		"<ruby>漢<rp>(</rp><rt>かん</rt><rp>)</rp>字<rt>じ</rt></ruby>": {"漢字", "かんじ"},
+	} {
+		if base, reading := ParseRuby(input); base != expected[0] || reading != expected[1] {
+			t.Errorf("%q: got %q, %q, expected %q, %q", input, base, reading, expected[0], expected[1])
+		}
+	}
+}
+
+func TestRubyValidator(t *testing.T) {
+	for _, test := range []struct {
+		word, pronunciation string
+		expected            int
+	}{
+		{"<ruby>食<rt>た</rt></ruby>べる", "たべる", 0},
+		{"<ruby>食<rt>く</rt></ruby>べる", "たべる", 1},
+		{"<ruby>食<rt>た</rt></ruby>べる", "", 0},
+		{"食べる", "くべる", 0},
+	} {
+		input := strings.Replace(strings.Replace(validEntry, "食べる\n", test.word+"\n", 1), "たべる", test.pronunciation, 1)
+		entry, _ := ParseEntryBlock(input)
+		entry.validate([]Validator{RubyValidator})
+		if len(entry.Warnings()) != test.expected || entry.IsDirty() {
+			t.Errorf("%q, %q: expected %d warnings, got %v", test.word, test.pronunciation, test.expected, entry.Warnings())
+		}
+	}
+}
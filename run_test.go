@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// writeInput writes data to a file in a new temporary directory and returns
+// the directory along with the file's path.
+func writeInput(t *testing.T, data string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "input.txt")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("failed to write input file: %v", err)
+	}
+	return dir, path
+}
+
+func TestLint(t *testing.T) {
+	dir, input := writeInput(t, validEntry+strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "{{c1::eat}}", "eat", 1))
+	output := filepath.Join(dir, "output.csv")
+	var stdout bytes.Buffer
+	if err := run([]string{"-lint", input, output}, &stdout, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "0002: [auto] translation is missing cloze deletion.") {
+		t.Errorf("report is missing dirty entry: %q", stdout.String())
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("output file was created: %v", err)
+	}
+	if err := run([]string{"-lint", input}, &stdout, io.Discard); err != nil {
+		t.Errorf("output file should be optional: %v", err)
+	}
+}
+
+func TestInvalidPrefix(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"decks/N2",
+		`decks\N2`,
+		"N2[1]",
+		"N2:JY",
+	} {
+		if err := ValidatePrefix(input); err != nil {
+			t.Logf("%q: %v", input, err)
+		} else {
+			t.Errorf("%q: this is invalid but no error returned!", input)
+		}
+	}
+	dir, input := writeInput(t, validEntry)
+	if err := run([]string{"-p", "decks/N2", input, filepath.Join(dir, "output.csv")}, io.Discard, io.Discard); err == nil {
+		t.Errorf("prefix with a slash was accepted")
+	}
+}
+
+func TestInvalidFlag(t *testing.T) {
+	var stderr bytes.Buffer
+	err := run([]string{"-no-such-flag"}, io.Discard, &stderr)
+	var usage usageError
+	if !errors.As(err, &usage) {
+		t.Fatalf("expected a usage error, got %v", err)
+	}
+	if !strings.Contains(stderr.String(), "flag provided but not defined: -no-such-flag") || !strings.Contains(stderr.String(), "Usage of jyuuyou2200:") {
+		t.Errorf("unexpected usage output: %q", stderr.String())
+	}
+	if err := run([]string{"-h"}, io.Discard, io.Discard); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("expected flag.ErrHelp, got %v", err)
+	}
+}
+
+func TestShowFields(t *testing.T) {
+	_, input := writeInput(t, strings.Replace(validEntry, "{{c1::eat}}", "to eat", 1))
+	var stdout bytes.Buffer
+	if err := run([]string{"-lint", "-show-fields", input}, &stdout, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, expected := range []string{"usage: {{c1::食べる}}", "translation: to eat"} {
+		if !strings.Contains(stdout.String(), expected) {
+			t.Errorf("report is missing %q: %q", expected, stdout.String())
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	dir, input := writeInput(t, validEntry+strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "{{c1::eat}}", "eat", 1))
+	output := filepath.Join(dir, "output.csv")
+	var stdout bytes.Buffer
+	if err := run([]string{"-count", input, output}, &stdout, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, expected := stdout.String(), "generated 1 entries. (dirty 1)\n"; got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("output file was created: %v", err)
+	}
+}
+
+func TestTiming(t *testing.T) {
+	dir, input := writeInput(t, validEntry)
+	var stderr bytes.Buffer
+	if err := run([]string{"-timing", input, filepath.Join(dir, "output.csv")}, io.Discard, &stderr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !regexp.MustCompile(`^timing: parse: \S+\ntiming: write: \S+\n$`).MatchString(stderr.String()) {
+		t.Errorf("unexpected timing output: %q", stderr.String())
+	}
+}
+
+func TestReportDirtySource(t *testing.T) {
+	input := strings.Replace(validEntry, "0001", "0001*", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "{{c1::eat}}", "eat", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0003* recheck", 1), "{{c1::eat}}", "eat", 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	writeReport(&buf, entries, false)
+	for _, expected := range []string{
+		"  0001: [manual] marked.\n",
+		"  0002: [auto] translation is missing cloze deletion.\n",
+		"  0003: [manual, auto] recheck\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("report is missing %q:\n%s", expected, buf.String())
+		}
+	}
+}
+
+func TestReportTable(t *testing.T) {
+	input := strings.Replace(strings.Replace(validEntry, "0001", "0001*", 1), "食べる\n", "食\n", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "{{c1::eat}}", "eat", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0003", 1), "{{c1::食べる}}", "<b>食べる", 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if dirty := writeReportTable(&buf, entries, false); dirty != 3 {
+		t.Errorf("expected 3 dirty entries, got %d", dirty)
+	}
+	expected := "found 3 dirty entries.\n\n" +
+		"  ID    word    field        reason\n" +
+		"  0001  食      -            marked.\n" +
+		"  0002  食べる  translation  translation is missing cloze deletion.\n" +
+		"  0003  食べる  usage        usage is missing cloze deletion.\n" +
+		"  0003  食べる  usage        usage: not all tags closed: [b]\n\n"
+	if buf.String() != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+	buf.Reset()
+	writeReportTable(&buf, entries, true)
+	rows := strings.Split(buf.String(), "\n")
+	if !strings.HasSuffix(rows[3], "marked.") || !strings.HasSuffix(rows[4], "translation is missing cloze deletion.") || !strings.HasSuffix(rows[6], "usage: not all tags closed: [b]") {
+		t.Errorf("rows not sorted by reason:\n%s", buf.String())
+	}
+}
+
+func TestGlob(t *testing.T) {
+	dir, _ := writeInput(t, validEntry)
+	for name, data := range map[string]string{
+		"b.txt":     strings.Replace(validEntry, "0001", "0003", 1),
+		"c.txt":     strings.Replace(validEntry, "0001", "0002", 1),
+		"notes.md":  "not an entry file",
+		"dup.other": validEntry,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write input file: %v", err)
+		}
+	}
+	output := filepath.Join(dir, "output.csv")
+	var stdout bytes.Buffer
+	if err := run([]string{"-p", "TEST", "-glob", filepath.Join(dir, "*.txt"), output}, &stdout, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("failed to read output file: %v", err)
+	}
+	var ids []string
+	for _, row := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		ids = append(ids, strings.SplitN(row, "\t", 2)[0])
+	}
+	if got, expected := strings.Join(ids, ","), "TEST-0001,TEST-0002,TEST-0003"; got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+	err = run([]string{"-glob", filepath.Join(dir, "*.*"), output}, io.Discard, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "duplicate entry ID 0001") {
+		t.Errorf("expected duplicate entry ID error, got %v", err)
+	}
+}
+
+func TestPrefixFromFilename(t *testing.T) {
+	dir, _ := writeInput(t, "")
+	for name, data := range map[string]string{
+		"verbs.txt":       validEntry,
+		"my nouns.v2.txt": strings.Replace(validEntry, "0001", "0002", 1),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write input file: %v", err)
+		}
+	}
+	output := filepath.Join(dir, "output.csv")
+	if err := run([]string{"-p", "JY", "-prefix-from-filename", "-glob", filepath.Join(dir, "*.txt"), output}, io.Discard, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("failed to read output file: %v", err)
+	}
+	rows := strings.Split(strings.TrimSpace(string(data)), "\n")
+	for index, expected := range []string{"JY-verbs-0001\t", "JY-my_nouns.v2-0002\t"} {
+		if !strings.HasPrefix(rows[index], expected) || !strings.Contains(rows[index], "[sound:"+strings.TrimSuffix(expected, "\t")+".mp3]") {
+			t.Errorf("%d: expected prefix %q, got %q", index+1, expected, rows[index])
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	dir, input := writeInput(t, validEntry)
+	output := filepath.Join(dir, "output.csv")
+	if err := run([]string{"-checksum", input, output}, io.Discard, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("failed to read output file: %v", err)
+	}
+	sum, err := os.ReadFile(output + ".sha256")
+	if err != nil {
+		t.Fatalf("failed to read checksum file: %v", err)
+	}
+	if got, expected := string(sum), fmt.Sprintf("%x  output.csv\n", sha256.Sum256(data)); got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestStrictHTML(t *testing.T) {
+	dir, input := writeInput(t, validEntry+strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "{{c1::eat}}", "<b>{{c1::eat}}", 1))
+	output := filepath.Join(dir, "output.csv")
+	var stdout bytes.Buffer
+	if err := run([]string{input, output}, &stdout, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, _ := os.ReadFile(output); strings.Count(string(data), "\n") != 1 {
+		t.Errorf("expected the malformed entry to be skipped as dirty, got %q", data)
+	}
+	err := run([]string{"-strict-html", input, output}, io.Discard, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "entry 0002") {
+		t.Errorf("expected a parse error for entry 0002, got %v", err)
+	} else {
+		t.Logf("%v", err)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	dir, input := writeInput(t, validEntry+
+		strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "{{c1::eat}}", "<b>{{c1::eat}}", 1)+
+		strings.Replace(strings.Replace(validEntry, "0001", "0003", 1), "{{c1::eat}}", "{{c1::食べる}}", 1))
+	output := filepath.Join(dir, "output.csv")
+	for _, test := range []struct {
+		args            []string
+		html, identical bool
+	}{
+		{[]string{"-profile", "lenient"}, false, false},
+		{[]string{}, true, false},
+		{[]string{"-profile", "standard"}, true, false},
+		{[]string{"-profile", "strict"}, true, true},
+		{[]string{"-profile", "strict", "-check-identical=false"}, true, false},
+	} {
+		var stdout bytes.Buffer
+		if err := run(append(test.args, "-lint", input, output), &stdout, io.Discard); err != nil {
+			t.Fatalf("%v: unexpected error: %v", test.args, err)
+		}
+		if got := strings.Contains(stdout.String(), "0002: [auto] "); got != test.html {
+			t.Errorf("%v: HTML check ran: %t, expected %t", test.args, got, test.html)
+		}
+		if got := strings.Contains(stdout.String(), "usage and translation are identical."); got != test.identical {
+			t.Errorf("%v: identical check ran: %t, expected %t", test.args, got, test.identical)
+		}
+	}
+	if err := run([]string{"-profile", "paranoid", input, output}, io.Discard, io.Discard); err == nil {
+		t.Errorf("unknown profile was accepted")
+	}
+}
+
+func TestCheckPhrases(t *testing.T) {
+	dir, input := writeInput(t, strings.Replace(validEntry, "\n食べる\n", "\nご飯を 食べる\n", 1))
+	output := filepath.Join(dir, "output.csv")
+	for _, test := range []struct {
+		args   []string
+		warned bool
+	}{
+		{[]string{}, false},
+		{[]string{"-check-phrases"}, true},
+		{[]string{"-profile", "strict"}, true},
+		{[]string{"-profile", "strict", "-allow-phrases"}, false},
+		{[]string{"-check-phrases", "-allow-phrases"}, false},
+	} {
+		var stdout bytes.Buffer
+		if err := run(append(test.args, "-lint", input, output), &stdout, io.Discard); err != nil {
+			t.Fatalf("%v: unexpected error: %v", test.args, err)
+		}
+		if got := strings.Contains(stdout.String(), "contains a space"); got != test.warned {
+			t.Errorf("%v: warned: %t, expected %t:\n%s", test.args, got, test.warned, stdout.String())
+		}
+	}
+}
+
+func TestStructureOnly(t *testing.T) {
+	dir, input := writeInput(t, validEntry+strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "{{c1::eat}}", "<b>eat", 1))
+	output := filepath.Join(dir, "output.csv")
+	var stdout bytes.Buffer
+	if err := run([]string{"-structure-only", "-profile", "strict", "-lint", input, output}, &stdout, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(stdout.String(), "0002:") {
+		t.Errorf("validations ran: %q", stdout.String())
+	}
+	if err := os.WriteFile(input, []byte(validEntry+strings.Replace(validEntry, "---\n", "", 1)), 0644); err != nil {
+		t.Fatalf("failed to write input file: %v", err)
+	}
+	if err := run([]string{"-structure-only", "-lint", input, output}, io.Discard, io.Discard); err == nil {
+		t.Errorf("structural problem was not reported")
+	}
+}
+
+func TestTagsOut(t *testing.T) {
+	dir, input := writeInput(t, validEntry+strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "verb", "verb,n5", 1))
+	output, tags := filepath.Join(dir, "output.csv"), filepath.Join(dir, "tags.tsv")
+	for _, noTagColumn := range []bool{false, true} {
+		args := []string{"-p", "TEST", "-tags-out", tags, input, output}
+		if noTagColumn {
+			args = append([]string{"-no-tag-column"}, args...)
+		}
+		if err := run(args, io.Discard, io.Discard); err != nil {
+			t.Fatalf("%v: unexpected error: %v", args, err)
+		}
+		data, err := os.ReadFile(tags)
+		if err != nil {
+			t.Fatalf("failed to read tags file: %v", err)
+		}
+		if got, expected := string(data), "TEST-0001\tverb\nTEST-0002\tverb,n5\n"; got != expected {
+			t.Errorf("%v: got %q, expected %q", args, got, expected)
+		}
+		data, err = os.ReadFile(output)
+		if err != nil {
+			t.Fatalf("failed to read output file: %v", err)
+		}
+		row := strings.Split(strings.Split(string(data), "\n")[0], "\t")
+		if hasTags := len(row) == 9 && row[8] == "verb"; hasTags == noTagColumn {
+			t.Errorf("%v: unexpected row: %q", args, row)
+		}
+	}
+}
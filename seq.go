@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+)
+
+// errYieldStopped is returned by parseEntries when its yield function asks to
+// stop.
+var errYieldStopped = errors.New("stopped by caller")
+
+// ParseSeq parses the entries read from f like NewEntriesFromFile, yielding
+// each entry as soon as it is complete rather than collecting them all. A
+// parse error is yielded in place of the entry it spoils and parsing carries
+// on with the next entry; an error reading f is yielded last.
+func ParseSeq(f io.Reader) iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		_, _, err := parseEntries(context.Background(), f, ParseOptions{MaxErrors: -1}, yield)
+		var errs EntriesParseErrors
+		if err == nil || errors.Is(err, errYieldStopped) || errors.As(err, &errs) {
+			return
+		}
+		yield(Entry{}, err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSeq(t *testing.T) {
+	input := validEntry +
+		strings.Replace(validEntry, "0001", "0003", 1) +
+		strings.Replace(validEntry, "0001", "00x2", 1) +
+		strings.Replace(validEntry, "0001", "0002", 1)
+	ids := make([]int64, 0)
+	errs := 0
+	for entry, err := range ParseSeq(strings.NewReader(input)) {
+		if err != nil {
+			t.Logf("%v", err)
+			errs++
+			continue
+		}
+		ids = append(ids, entry.ID())
+	}
+	if errs != 1 {
+		t.Errorf("expected 1 error, got %d", errs)
+	}
+	if len(ids) != 3 || ids[0] != 1 || ids[1] != 3 || ids[2] != 2 {
+		t.Errorf("expected entries in input order, got %v", ids)
+	}
+	for entry := range ParseSeq(strings.NewReader(input)) {
+		if entry.ID() != 1 {
+			t.Errorf("unexpected entry %d", entry.ID())
+		}
+		break
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// OutputSink creates the named outputs of the modes that write several
+// files, such as WriteByTag.
+type OutputSink interface {
+	Writer(name string) (io.WriteCloser, error)
+}
+
+// DirSink is an OutputSink creating files in a directory, which is created
+// along with the first file.
+type DirSink string
+
+// Writer creates the file name in the directory, replacing any existing file.
+func (dir DirSink) Writer(name string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(string(dir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %s: %v", dir, err)
+	}
+	path := filepath.Join(string(dir), name)
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output file: %s: %v", path, err)
+	}
+	return f, nil
+}
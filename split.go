@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// WriteByTag writes one file per tag to sink, named after the tag with an
+// extension for opts.Format, holding the entries that carry that tag. It returns the number of entries written
+// per file name.
+func (entries Entries) WriteByTag(sink OutputSink, opts WriteOptions) (map[string]int, error) {
+	files := make(map[string][]string)
+	for _, entry := range entries {
+		if entry.ID() == 0 || entry.IsDirty() {
+			continue
+		}
+		for _, tag := range strings.Split(entry.outputTags(opts), ",") {
+			if tag == "" {
+				continue
+			}
+			name := TagFilename(tag) + formatExtension(opts.Format)
+			tags := files[name]
+			if len(tags) != 0 && tags[0] != tag {
+				return nil, fmt.Errorf("tags %q and %q both map to file %q", tags[0], tag, name)
+			}
+			if len(tags) == 0 {
+				files[name] = []string{tag}
+			}
+		}
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	counts := make(map[string]int, len(files))
+	for _, name := range names {
+		tag := files[name][0]
+		tagged := Entries{}
+		for index, entry := range entries {
+			for _, t := range strings.Split(entry.outputTags(opts), ",") {
+				if entry.ID() != 0 && t == tag {
+					tagged[index] = entry
+					break
+				}
+			}
+		}
+		w, err := sink.Writer(name)
+		if err != nil {
+			return counts, err
+		}
+		count, _, err := tagged.Write(w, opts)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return counts, fmt.Errorf("failed to write output file: %s: %v", name, err)
+		}
+		counts[name] = count
+	}
+	return counts, nil
+}
+
+// TagFilename returns a flat file name, without an extension, for the tag's
+// output file.
+func TagFilename(tag string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`/\:*?"<>| `, r) {
+			return '_'
+		}
+		return r
+	}, tag)
+	if strings.Trim(name, ".") == "" {
+		name = strings.Repeat("_", len(name))
+	}
+	return name
+}
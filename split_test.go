@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteByTag(t *testing.T) {
+	entries, err := NewEntriesFromFile(strings.NewReader(
+		strings.Replace(validEntry, "verb", "verb,n2", 1) +
+			strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "verb", "grammar/particles", 1),
+	))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dir := t.TempDir()
+	counts, err := entries.WriteByTag(DirSink(filepath.Join(dir, "tags")), WriteOptions{Prefix: "TEST"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, expected := range map[string]string{
+		"verb.csv":              "TEST-0001\t",
+		"n2.csv":                "TEST-0001\t",
+		"grammar_particles.csv": "TEST-0002\t",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, "tags", name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if counts[name] != 1 || !strings.HasPrefix(string(data), expected) {
+			t.Errorf("%s: unexpected contents: %q", name, data)
+		}
+	}
+	if len(counts) != 3 {
+		t.Errorf("unexpected files: %v", counts)
+	}
+}
+
+// memorySink is an OutputSink keeping the outputs in memory.
+type memorySink map[string]*bytes.Buffer
+
+func (sink memorySink) Writer(name string) (io.WriteCloser, error) {
+	if _, ok := sink[name]; ok {
+		return nil, fmt.Errorf("output written twice: %s", name)
+	}
+	sink[name] = &bytes.Buffer{}
+	return nopCloser{sink[name]}, nil
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func TestWriteByTagSink(t *testing.T) {
+	entries, err := NewEntriesFromFile(strings.NewReader(
+		strings.Replace(validEntry, "verb", "verb,n2", 1) +
+			strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "verb", "n2", 1),
+	))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sink := memorySink{}
+	counts, err := entries.WriteByTag(sink, WriteOptions{Prefix: "TEST"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sink) != 2 || counts["verb.csv"] != 1 || counts["n2.csv"] != 2 {
+		t.Errorf("unexpected outputs: %v", counts)
+	}
+	if rows := strings.Split(strings.TrimSuffix(sink["n2.csv"].String(), "\n"), "\n"); len(rows) != 2 {
+		t.Errorf("n2.csv: unexpected rows: %q", rows)
+	}
+}
+
+func TestWriteByTagFormat(t *testing.T) {
+	entries, err := NewEntriesFromFile(strings.NewReader(validEntry))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for format, name := range map[string]string{
+		FormatJSONL:   "verb.jsonl",
+		FormatText:    "verb.txt",
+		FormatQuizlet: "verb.txt",
+		FormatBasic:   "verb.csv",
+	} {
+		sink := memorySink{}
+		if _, err := entries.WriteByTag(sink, WriteOptions{Prefix: "TEST", Format: format}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", format, err)
+		}
+		if _, ok := sink[name]; !ok || len(sink) != 1 {
+			t.Errorf("%s: expected %s, got %v", format, name, sink)
+		}
+	}
+}
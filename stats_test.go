@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompleteness(t *testing.T) {
+	input := validEntry +
+		strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "たべる", "", 1) +
+		strings.Replace(strings.Replace(strings.Replace(validEntry, "0001", "0004", 1), "たべる", "", 1), "to eat", "", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0005", 1), "verb", "", 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	completeness := entries.Completeness()
+	for name, expected := range map[string]float64{
+		"usage":         1,
+		"translation":   1,
+		"word":          1,
+		"pronunciation": 0.5,
+		"definition":    0.75,
+		"tags":          0.75,
+	} {
+		if got := completeness[name]; got != expected {
+			t.Errorf("%s: got %v, expected %v", name, got, expected)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeTagsFromFile(t *testing.T) {
+	entries, err := NewEntriesFromFile(strings.NewReader(validEntry + strings.Replace(validEntry, "0001", "0002", 1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	warnings, err := entries.MergeTagsFromFile(strings.NewReader("0001\tverb,n2\n2\tcommon\n0003\tunknown\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "unknown entry ID: 3") {
+		t.Errorf("expected a warning for the unknown ID, got %v", warnings)
+	}
+	for index, expected := range []string{"verb,n2", "verb,common"} {
+		if got := strings.Join(entries[index].Tags(), ","); got != expected {
+			t.Errorf("%04d: got %q, expected %q", index+1, got, expected)
+		}
+	}
+}
+
+func TestHashtags(t *testing.T) {
+	input := strings.Replace(validEntry, "0001", "0001 #verb #jlpt", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0002* #n2 check this", 1), "verb", "", 1)
+	entries, err := NewEntriesFromFileWithOptions(strings.NewReader(input), ParseOptions{Hashtags: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for index, expected := range []struct{ tags, comment string }{
+		{"verb,jlpt", ""},
+		{"n2", "check this"},
+	} {
+		entry := entries[index]
+		if got := strings.Join(entry.Tags(), ","); got != expected.tags {
+			t.Errorf("%04d: tags: got %q, expected %q", index+1, got, expected.tags)
+		}
+		if entry.Comment() != expected.comment {
+			t.Errorf("%04d: comment: got %q, expected %q", index+1, entry.Comment(), expected.comment)
+		}
+	}
+
+	// Without -hashtags the tags line is kept as written.
+	entries, err = NewEntriesFromFile(strings.NewReader(strings.Replace(validEntry, "verb", "verb,verb,", 1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.Join(entries[0].Tags(), ","); got != "verb,verb," {
+		t.Errorf("tags changed without hashtags: %q", got)
+	}
+}
+
+func TestLowerTags(t *testing.T) {
+	entry, _ := ParseEntryBlock(strings.Replace(validEntry, "verb", "Verb,verb,N2", 1))
+	row := entry.CSV(WriteOptions{Prefix: "TEST", LowerTags: true, DefaultTags: []string{"JLPT", "n2"}})
+	if got, expected := row[len(row)-1], "verb,n2,jlpt"; got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestTagHierarchySeparator(t *testing.T) {
+	entry, _ := ParseEntryBlock(strings.Replace(validEntry, "verb", "grammar/particles,verb", 1))
+	row := entry.CSV(WriteOptions{Prefix: "TEST", TagHierarchySeparator: "/", DefaultTags: []string{"jlpt/n2"}})
+	if got, expected := row[len(row)-1], "grammar::particles,verb,jlpt::n2"; got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Validator checks a parsed entry and returns a comment for each problem
+// found. Any comment marks the entry dirty.
+type Validator interface {
+	Validate(*Entry) []string
+}
+
+// ValidatorFunc adapts an ordinary function to the Validator interface.
+type ValidatorFunc func(*Entry) []string
+
+func (f ValidatorFunc) Validate(e *Entry) []string { return f(e) }
+
+// Warning wraps a validator so that its comments are recorded as warnings,
+// which are reported but do not mark the entry dirty.
+func Warning(v Validator) Validator { return warning{v} }
+
+type warning struct{ Validator }
+
+// Fatal wraps a validator so that its comments are parse errors rather than
+// marking the entry dirty.
+func Fatal(v Validator) Validator { return fatal{v} }
+
+type fatal struct{ Validator }
+
+var (
+	ClozeValidator = ValidatorFunc(validateCloze)
+	HTMLValidator  = ValidatorFunc(validateHTML)
+
+	// IdenticalValidator flags entries whose usage and translation are the
+	// same, which is usually a copy-paste error.
+	IdenticalValidator = ValidatorFunc(validateIdentical)
+
+	// MojibakeValidator flags fields containing the Unicode replacement
+	// character, which is left behind by a failed encoding conversion.
+	MojibakeValidator = ValidatorFunc(validateMojibake)
+
+	// DelimiterTagValidator flags entries with a tag equal to the entry
+	// delimiter, which means the fields of the entry are misaligned.
+	DelimiterTagValidator = ValidatorFunc(validateDelimiterTag)
+
+	// InvisibleValidator flags fields containing zero-width spaces, word
+	// joiners or byte order marks, which break searching in Anki.
+	InvisibleValidator = ValidatorFunc(validateInvisible)
+
+	// NFCValidator flags fields not in Unicode normalization form C, whose
+	// text Anki does not match with the same text in composed form.
+	NFCValidator = ValidatorFunc(validateNFC)
+
+	// ExtraHTMLValidator checks the markup of the word, pronunciation and
+	// definition, which HTMLValidator leaves alone.
+	ExtraHTMLValidator = ValidatorFunc(validateExtraHTML)
+
+	// InputWordValidator warns when the cloze answer differs from the word,
+	// which is usually a conjugated form worth reviewing.
+	InputWordValidator = Warning(ValidatorFunc(validateInputWord))
+
+	// EntityValidator flags HTML entity references with unknown names.
+	EntityValidator = ValidatorFunc(validateEntities)
+
+	// NestedTagValidator warns about a tag opened directly inside an
+	// identical tag, such as <b><b>, which is usually left over from a merge.
+	NestedTagValidator = Warning(ValidatorFunc(validateNestedTags))
+
+	// ClozeCountValidator warns when the usage and translation have a
+	// different number of cloze deletions.
+	ClozeCountValidator = Warning(ValidatorFunc(validateClozeCount))
+
+	// ClozeNumbersValidator warns when the usage and translation use
+	// different sets of cloze numbers, so their cards cannot correspond.
+	ClozeNumbersValidator = Warning(ValidatorFunc(validateClozeNumbers))
+
+	// SelfDefineValidator warns when the definition only repeats the word.
+	SelfDefineValidator = Warning(ValidatorFunc(validateSelfDefine))
+
+	// WholeClozeValidator warns when a single cloze wraps the whole
+	// translation, which leaves nothing on the card to answer from.
+	WholeClozeValidator = Warning(ValidatorFunc(validateWholeCloze))
+
+	// RubyValidator warns when the <ruby> reading of the word disagrees with
+	// the pronunciation.
+	RubyValidator = Warning(ValidatorFunc(validateRuby))
+
+	// ClozeAnswersValidator warns when two clozes in the usage or
+	// translation hide the same answer, which makes the cards ambiguous.
+	ClozeAnswersValidator = Warning(ValidatorFunc(validateClozeAnswers))
+
+	// SwappedValidator warns when the translation looks more Japanese than
+	// the usage, which usually means the two fields are swapped.
+	SwappedValidator = Warning(ValidatorFunc(validateSwapped))
+
+	// ReadingValidator warns when the pronunciation repeats a word written
+	// with kanji instead of giving its reading.
+	ReadingValidator = Warning(ValidatorFunc(validateReading))
+
+	// TagOrderValidator warns when the tags of an entry are not sorted,
+	// which makes diffs of the input noisier.
+	TagOrderValidator = Warning(ValidatorFunc(validateTagOrder))
+
+	// PhraseValidator warns when the word contains an ASCII or ideographic
+	// space, which usually means a phrase slipped in or two fields were
+	// merged.
+	PhraseValidator = Warning(ValidatorFunc(validatePhrase))
+)
+
+var (
+	entityRegexp        = regexp.MustCompile(`&([^\s&;]*);`)
+	clozeRegexp         = regexp.MustCompile(`{{c([[:digit:]]+)::(.*?)}}`)
+	openTagsRegexp      = regexp.MustCompile(`<([[:alnum:]]+)[^<>]*>\s*<([[:alnum:]]+)[^<>]*>`)
+	numericEntityRegexp = regexp.MustCompile(`^#([[:digit:]]+|[xX][[:xdigit:]]+)$`)
+
+	// KnownEntities are the named entity references accepted by
+	// EntityValidator.
+	KnownEntities = map[string]bool{
+		"amp": true, "lt": true, "gt": true, "quot": true, "apos": true,
+		"nbsp": true, "ensp": true, "emsp": true, "thinsp": true, "zwj": true, "zwnj": true,
+		"hellip": true, "mdash": true, "ndash": true, "middot": true, "bull": true,
+		"lsquo": true, "rsquo": true, "ldquo": true, "rdquo": true, "laquo": true, "raquo": true,
+		"times": true, "divide": true, "deg": true, "yen": true, "copy": true, "reg": true, "trade": true,
+	}
+)
+
+// DefaultValidators returns the validators used when none are configured.
+func DefaultValidators() []Validator { return baseValidators(true, true) }
+
+// BasicValidators returns the default validators without ClozeValidator, for
+// entries written as front/back cards.
+func BasicValidators() []Validator { return baseValidators(false, true) }
+
+// baseValidators returns the validators always run on entries, optionally
+// leaving out ClozeValidator and HTMLValidator.
+func baseValidators(cloze, html bool) []Validator {
+	validators := make([]Validator, 0, 4)
+	if cloze {
+		validators = append(validators, ClozeValidator)
+	}
+	if html {
+		validators = append(validators, HTMLValidator)
+	}
+	return append(validators, MojibakeValidator, InvisibleValidator, DelimiterTagValidator)
+}
+
+// Profile is a named preset of validations, selected with -profile.
+type Profile struct {
+	// HTML checks the markup of the usage and translation.
+	HTML bool
+	// Checks names the boolean check flags the profile turns on.
+	Checks []string
+}
+
+// Profiles are the presets accepted by -profile. A check flag given
+// explicitly overrides the profile.
+var Profiles = map[string]Profile{
+	"lenient":  {HTML: false},
+	"standard": {HTML: true},
+	"strict": {HTML: true, Checks: []string{
+		"html-all-fields",
+		"check-identical",
+		"check-entities",
+		"check-input-word",
+		"check-nested-tags",
+		"check-cloze-count",
+		"check-cloze-numbers",
+		"check-self-define",
+		"check-whole-cloze",
+		"check-ruby",
+		"check-cloze-answers",
+		"check-swapped",
+		"check-reading",
+		"validate-unicode-nfc",
+		"check-tag-order",
+		"check-phrases",
+	}},
+}
+
+type namedField struct {
+	name, data string
+}
+
+// textFields returns the fields parsed from an entry's source lines.
+func (e Entry) textFields() []namedField {
+	fields := []namedField{
+		{"usage", e.Usage()},
+		{"translation", e.Translation()},
+		{"word", e.Word()},
+		{"pronunciation", e.Pronunciation()},
+		{"definition", e.Definition()},
+		{"tags", strings.Join(e.Tags(), ",")},
+	}
+	for _, name := range e.extraFields() {
+		fields = append(fields, namedField{name, e.fields[name]})
+	}
+	return fields
+}
+
+// validate runs the validators on the entry and returns the comments of any
+// Fatal validators, which are not recorded on the entry.
+func (e *Entry) validate(validators []Validator) []string {
+	problems := make([]string, 0)
+	for _, validator := range validators {
+		if _, ok := validator.(warning); ok {
+			e.warnings = append(e.warnings, validator.Validate(e)...)
+			continue
+		}
+		if _, ok := validator.(fatal); ok {
+			problems = append(problems, validator.Validate(e)...)
+			continue
+		}
+		for _, comment := range validator.Validate(e) {
+			e.dirty = true
+			e.flagged = true
+			e.comments = append(e.comments, comment)
+		}
+	}
+	return problems
+}
+
+// NewTagVocabularyFromFile reads the allowed tags, one per line, for use with
+// TagVocabularyValidator.
+func NewTagVocabularyFromFile(f io.Reader) (map[string]bool, error) {
+	return readWordList(f)
+}
+
+// TagVocabularyValidator flags entries with tags missing from vocabulary. With
+// foldCase, tags differing from the vocabulary only in case are allowed.
+func TagVocabularyValidator(vocabulary map[string]bool, foldCase bool) Validator {
+	if foldCase {
+		folded := make(map[string]bool, len(vocabulary))
+		for tag := range vocabulary {
+			folded[strings.ToLower(tag)] = true
+		}
+		vocabulary = folded
+	}
+	return ValidatorFunc(func(e *Entry) []string {
+		unknown := make([]string, 0)
+		for _, tag := range e.Tags() {
+			key := strings.TrimSpace(tag)
+			if foldCase {
+				key = strings.ToLower(key)
+			}
+			if key != "" && !vocabulary[key] {
+				unknown = append(unknown, tag)
+			}
+		}
+		if len(unknown) != 0 {
+			return []string{fmt.Sprintf("tags not in vocabulary: %s.", strings.Join(unknown, ", "))}
+		}
+		return nil
+	})
+}
+
+// MaxHTMLDepthValidator warns about fields with tags nested more than depth
+// levels deep, which is almost always accidental. Fields with malformed markup
+// are left to HTMLValidator.
+func MaxHTMLDepthValidator(depth int) Validator {
+	return Warning(ValidatorFunc(func(e *Entry) []string {
+		comments := make([]string, 0)
+		for _, field := range e.textFields() {
+			if e.IsPlain(field.name) {
+				continue
+			}
+			if n, err := HTMLDepth(field.data); err == nil && n > depth {
+				comments = append(comments, fmt.Sprintf("%s has tags nested %d levels deep, more than %d.", field.name, n, depth))
+			}
+		}
+		return comments
+	}))
+}
+
+func validateCloze(e *Entry) []string {
+	comments := make([]string, 0)
+	for _, field := range e.textFields()[:2] {
+		if err := CheckClozeBraces(field.data); err != nil {
+			comments = append(comments, fmt.Sprintf("%s has %v.", field.name, err))
+		} else if ClozeDeletionRegexp.FindStringSubmatch(field.data) == nil {
+			comments = append(comments, fmt.Sprintf("%s is missing cloze deletion.", field.name))
+		}
+	}
+	return comments
+}
+
+func validateHTML(e *Entry) []string {
+	comments := make([]string, 0)
+	for _, field := range e.textFields()[:2] {
+		if e.IsPlain(field.name) {
+			continue
+		}
+		if err := IsValidHTML(field.data); err != nil {
+			comments = append(comments, fmt.Sprintf("%s: %v", field.name, err))
+		}
+	}
+	return comments
+}
+
+func validateIdentical(e *Entry) []string {
+	if strings.TrimSpace(e.Usage()) == strings.TrimSpace(e.Translation()) {
+		return []string{"usage and translation are identical."}
+	}
+	return nil
+}
+
+func validateMojibake(e *Entry) []string {
+	comments := make([]string, 0)
+	for _, field := range e.textFields() {
+		if strings.ContainsRune(field.data, utf8.RuneError) {
+			comments = append(comments, fmt.Sprintf("%s contains a replacement character (U+FFFD).", field.name))
+		}
+	}
+	return comments
+}
+
+func validateInvisible(e *Entry) []string {
+	comments := make([]string, 0)
+	for _, field := range e.textFields() {
+		found := make([]string, 0)
+		position := 0
+		for _, r := range field.data {
+			position++
+			if IsInvisible(r) {
+				found = append(found, fmt.Sprintf("%U at character %d", r, position))
+			}
+		}
+		if len(found) != 0 {
+			comments = append(comments, fmt.Sprintf("%s contains invisible %s.", field.name, strings.Join(found, ", ")))
+		}
+	}
+	return comments
+}
+
+func validateNFC(e *Entry) []string {
+	comments := make([]string, 0)
+	for _, field := range e.textFields() {
+		if !norm.NFC.IsNormalString(field.data) {
+			comments = append(comments, fmt.Sprintf("%s is not in Unicode normalization form C.", field.name))
+		}
+	}
+	return comments
+}
+
+func validateEntities(e *Entry) []string {
+	comments := make([]string, 0)
+	for _, field := range e.textFields() {
+		for _, match := range entityRegexp.FindAllStringSubmatch(field.data, -1) {
+			if !KnownEntities[match[1]] && !numericEntityRegexp.MatchString(match[1]) {
+				comments = append(comments, fmt.Sprintf("%s contains unknown entity %s.", field.name, match[0]))
+			}
+		}
+	}
+	return comments
+}
+
+func validateExtraHTML(e *Entry) []string {
+	comments := make([]string, 0)
+	for _, field := range e.textFields()[2:5] {
+		if e.IsPlain(field.name) {
+			continue
+		}
+		if err := IsValidHTML(field.data); err != nil {
+			comments = append(comments, fmt.Sprintf("%s: %v", field.name, err))
+		}
+	}
+	return comments
+}
+
+func validateDelimiterTag(e *Entry) []string {
+	for _, tag := range e.Tags() {
+		if strings.TrimSpace(tag) == e.recordSeparator() {
+			return []string{fmt.Sprintf("tags contain the entry delimiter %q; fields may be misaligned.", e.recordSeparator())}
+		}
+	}
+	return nil
+}
+
+func validateInputWord(e *Entry) []string {
+	if input, word := strings.TrimSpace(e.Input()), strings.TrimSpace(e.Word()); input != "" && input != word {
+		return []string{fmt.Sprintf("cloze answer %q differs from word %q.", input, word)}
+	}
+	return nil
+}
+
+func validateNestedTags(e *Entry) []string {
+	comments := make([]string, 0)
+	for _, field := range e.textFields() {
+		for _, match := range openTagsRegexp.FindAllStringSubmatch(field.data, -1) {
+			if strings.EqualFold(match[1], match[2]) {
+				comments = append(comments, fmt.Sprintf("%s: redundant nested tag: %s", field.name, match[0]))
+				break
+			}
+		}
+	}
+	return comments
+}
+
+func validateClozeCount(e *Entry) []string {
+	usage := len(clozeRegexp.FindAllString(e.Usage(), -1))
+	translation := len(clozeRegexp.FindAllString(e.Translation(), -1))
+	if usage != translation {
+		return []string{fmt.Sprintf("usage has %d cloze deletions but translation has %d.", usage, translation)}
+	}
+	return nil
+}
+
+func validateClozeNumbers(e *Entry) []string {
+	usage, translation := clozeNumbers(e.Usage()), clozeNumbers(e.Translation())
+	if !equalStrings(usage, translation) {
+		return []string{fmt.Sprintf(
+			"usage uses clozes %s but translation uses %s.",
+			strings.Join(usage, ", "),
+			strings.Join(translation, ", "),
+		)}
+	}
+	return nil
+}
+
+// clozeNumbers returns the distinct cloze numbers used in s, such as "c1",
+// in ascending order.
+func clozeNumbers(s string) []string {
+	seen := make(map[int]bool)
+	for _, match := range clozeRegexp.FindAllStringSubmatch(s, -1) {
+		n, _ := strconv.Atoi(match[1])
+		seen[n] = true
+	}
+	numbers := make([]int, 0, len(seen))
+	for n := range seen {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	names := make([]string, len(numbers))
+	for index, n := range numbers {
+		names[index] = fmt.Sprintf("c%d", n)
+	}
+	return names
+}
+
+func validateSelfDefine(e *Entry) []string {
+	if word := strings.TrimSpace(e.Word()); word != "" && word == strings.TrimSpace(e.Definition()) {
+		return []string{fmt.Sprintf("definition only repeats the word %q.", word)}
+	}
+	return nil
+}
+
+func validateWholeCloze(e *Entry) []string {
+	translation := e.Translation()
+	if len(clozeRegexp.FindAllString(translation, -1)) != 1 {
+		return nil
+	}
+	if strings.TrimSpace(StripHTML(clozeRegexp.ReplaceAllString(translation, ""))) == "" {
+		return []string{"translation is a single cloze covering the whole field."}
+	}
+	return nil
+}
+
+func validateRuby(e *Entry) []string {
+	pronunciation := strings.TrimSpace(StripHTML(e.Pronunciation()))
+	if pronunciation == "" || !rubyRegexp.MatchString(e.Word()) {
+		return nil
+	}
+	if _, reading := ParseRuby(e.Word()); strings.TrimSpace(reading) != pronunciation {
+		return []string{fmt.Sprintf("word reading %q differs from pronunciation %q.", strings.TrimSpace(reading), pronunciation)}
+	}
+	return nil
+}
+
+func validateClozeAnswers(e *Entry) []string {
+	comments := make([]string, 0)
+	for _, field := range e.textFields()[:2] {
+		seen := make(map[string]string)
+		for _, match := range clozeRegexp.FindAllStringSubmatch(field.data, -1) {
+			answer := strings.TrimSpace(strings.SplitN(match[2], "::", 2)[0])
+			if previous, ok := seen[answer]; ok {
+				comments = append(comments, fmt.Sprintf("%s: clozes c%s and c%s share the answer %q.", field.name, previous, match[1], answer))
+				continue
+			}
+			seen[answer] = match[1]
+		}
+	}
+	return comments
+}
+
+func validateSwapped(e *Entry) []string {
+	usage, translation := japaneseRatio(e.Usage()), japaneseRatio(e.Translation())
+	if translation > usage {
+		return []string{fmt.Sprintf(
+			"translation looks more Japanese than usage (%.0f%% vs %.0f%%); are they swapped?",
+			translation*100,
+			usage*100,
+		)}
+	}
+	return nil
+}
+
+// japaneseRatio returns the fraction of the letters in s, ignoring markup and
+// cloze syntax, that are kanji or kana.
+func japaneseRatio(s string) float64 {
+	letters, japanese := 0, 0
+	for _, r := range StripHTML(clozeRegexp.ReplaceAllString(s, "$2")) {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) {
+			japanese++
+		}
+	}
+	if letters == 0 {
+		return 0
+	}
+	return float64(japanese) / float64(letters)
+}
+
+func validateReading(e *Entry) []string {
+	word := strings.TrimSpace(e.Word())
+	if word == "" || word != strings.TrimSpace(e.Pronunciation()) {
+		return nil
+	}
+	for _, r := range word {
+		if unicode.Is(unicode.Han, r) {
+			return []string{fmt.Sprintf("pronunciation repeats the word %q instead of giving its reading.", word)}
+		}
+	}
+	return nil
+}
+
+func validateTagOrder(e *Entry) []string {
+	tags := make([]string, 0, len(e.Tags()))
+	for _, tag := range e.Tags() {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if !sort.StringsAreSorted(tags) {
+		return []string{fmt.Sprintf("tags are not sorted: %s.", strings.Join(tags, ", "))}
+	}
+	return nil
+}
+
+func validatePhrase(e *Entry) []string {
+	word := strings.TrimSpace(StripHTML(e.Word()))
+	if strings.ContainsAny(word, " \u3000") {
+		return []string{fmt.Sprintf("word %q contains a space.", word)}
+	}
+	return nil
+}
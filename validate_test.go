@@ -0,0 +1,366 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCustomValidator(t *testing.T) {
+	partOfSpeech := ValidatorFunc(func(e *Entry) []string {
+		if !strings.HasPrefix(e.Definition(), "(") {
+			return []string{"definition is missing part of speech."}
+		}
+		return nil
+	})
+	input := validEntry + strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "to eat", "(v) to eat", 1)
+	entries, err := NewEntriesFromFileWithOptions(strings.NewReader(input), ParseOptions{
+		Validators: append(DefaultValidators(), partOfSpeech),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !entries[0].IsDirty() || strings.Join(entries[0].Comments(), "") != "definition is missing part of speech." {
+		t.Errorf("0001: expected custom validator comment, got %v", entries[0].Comments())
+	}
+	if entries[1].IsDirty() {
+		t.Errorf("0002: unexpected comments: %v", entries[1].Comments())
+	}
+}
+
+func TestIdenticalValidator(t *testing.T) {
+	input := strings.Replace(validEntry, "{{c1::eat}}", " {{c1::食べる}} ", 1)
+	entries, err := NewEntriesFromFileWithOptions(strings.NewReader(input), ParseOptions{
+		Validators: append(DefaultValidators(), IdenticalValidator),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !entries[0].IsDirty() {
+		t.Errorf("identical usage and translation not marked dirty")
+	}
+	if entries, _ := NewEntriesFromFile(strings.NewReader(input)); entries[0].IsDirty() {
+		t.Errorf("identical check ran without being enabled: %v", entries[0].Comments())
+	}
+}
+
+func TestMojibakeValidator(t *testing.T) {
+	entry, errs := ParseEntryBlock(strings.Replace(validEntry, "to eat", "to e\uFFFDt", 1))
+	if !entry.IsDirty() || len(errs) != 1 || errs[0].Error() != "definition contains a replacement character (U+FFFD)." {
+		t.Errorf("expected replacement character in definition to be reported, got %v", errs)
+	}
+}
+
+func TestEntityValidator(t *testing.T) {
+	for input, expected := range map[string]int{
+		"to&nbsp;eat &amp; &#12354; &#x3042;": 0,
+		"to&nsbp;eat":                         1,
+		"&foo; &bar;":                         2,
+	} {
+		entry, _ := ParseEntryBlock(strings.Replace(validEntry, "to eat", input, 1))
+		if comments := EntityValidator.Validate(&entry); len(comments) != expected {
+			t.Errorf("%s: expected %d problems, got %v", input, expected, comments)
+		}
+	}
+}
+
+func TestHTMLValidator(t *testing.T) {
+	for field, input := range map[string]string{
+		"usage":       strings.Replace(validEntry, "{{c1::食べる}}", "<b>{{c1::食べる}}", 1),
+		"translation": strings.Replace(validEntry, "{{c1::eat}}", "{{c1::eat}}</i>", 1),
+	} {
+		entry, _ := ParseEntryBlock(input)
+		if comments := HTMLValidator.Validate(&entry); len(comments) != 1 || !strings.HasPrefix(comments[0], field+": ") {
+			t.Errorf("%s: expected a %s problem, got %v", input, field, comments)
+		}
+	}
+}
+
+func TestExtraHTMLValidator(t *testing.T) {
+	for _, input := range []string{
+		"<b>to eat",
+		"to eat</b>",
+		"<b>to <i>eat</b></i>",
+	} {
+		entry, errs := ParseEntryBlock(strings.Replace(validEntry, "to eat", input, 1))
+		if len(errs) != 0 {
+			t.Errorf("%s: definition HTML checked by default: %v", input, errs)
+		}
+		if comments := ExtraHTMLValidator.Validate(&entry); len(comments) != 1 || !strings.HasPrefix(comments[0], "definition: ") {
+			t.Errorf("%s: expected a definition problem, got %v", input, comments)
+		} else {
+			t.Logf("%s: %v", input, comments)
+		}
+	}
+}
+
+func TestDelimiterTagValidator(t *testing.T) {
+	for _, input := range []string{"---", "verb,---"} {
+		entry, errs := ParseEntryBlock(strings.Replace(validEntry, "verb\n", input+"\n", 1))
+		if !entry.IsDirty() || len(errs) != 1 {
+			t.Errorf("%s: expected delimiter tag to be reported, got %v", input, errs)
+		}
+	}
+}
+
+func TestInputWordValidator(t *testing.T) {
+	input := validEntry + strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "{{c1::食べる}}", "もう{{c1::食べた}}", 1)
+	entries, err := NewEntriesFromFileWithOptions(strings.NewReader(input), ParseOptions{
+		Validators: append(DefaultValidators(), InputWordValidator),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries[0].Warnings()) != 0 {
+		t.Errorf("0001: unexpected warnings: %v", entries[0].Warnings())
+	}
+	if entries[1].IsDirty() || len(entries[1].Warnings()) != 1 {
+		t.Errorf("0002: expected a warning without marking dirty, got %v, %v", entries[1].Comments(), entries[1].Warnings())
+	}
+}
+
+func TestNestedTagValidator(t *testing.T) {
+	for _, input := range []string{
+		"<b><b>x</b></b>",
+		"<b class=\"a\"> <B>x</B></b>",
+		"<span>x</span><b><b>y</b></b>",
+	} {
+		entry, _ := ParseEntryBlock(strings.Replace(validEntry, "to eat", input, 1))
+		entry.validate([]Validator{NestedTagValidator})
+		if entry.IsDirty() || len(entry.Warnings()) != 1 {
+			t.Errorf("%s: expected a warning without marking dirty, got %v", input, entry.Warnings())
+		} else {
+			t.Logf("%s: %v", input, entry.Warnings())
+		}
+	}
+	for _, input := range []string{"<b>x</b><b>y</b>", "<b><i>x</i></b>", "<b>x<b>y</b></b>"} {
+		entry, _ := ParseEntryBlock(strings.Replace(validEntry, "to eat", input, 1))
+		entry.validate([]Validator{NestedTagValidator})
+		if len(entry.Warnings()) != 0 {
+			t.Errorf("%s: unexpected warnings: %v", input, entry.Warnings())
+		}
+	}
+}
+
+func TestClozeCountValidator(t *testing.T) {
+	twoClozes := strings.Replace(validEntry, "{{c1::食べる}}", "{{c1::ご飯}}を{{c2::食べる}}", 1)
+	for input, expected := range map[string]int{
+		validEntry: 0,
+		twoClozes:  1,
+		strings.Replace(twoClozes, "{{c1::eat}}", "{{c2::eat}} {{c1::rice}}", 1): 0,
+	} {
+		entry, _ := ParseEntryBlock(input)
+		entry.validate([]Validator{ClozeCountValidator})
+		if len(entry.Warnings()) != expected || entry.IsDirty() {
+			t.Errorf("%q: expected %d warnings, got %v", entry.Usage(), expected, entry.Warnings())
+		}
+	}
+}
+
+func TestClozeNumbersValidator(t *testing.T) {
+	twoClozes := strings.Replace(validEntry, "{{c1::食べる}}", "{{c1::ご飯}}を{{c2::食べる}}", 1)
+	for input, expected := range map[string]string{
+		validEntry: "",
+		twoClozes:  "usage uses clozes c1, c2 but translation uses c1.",
+		strings.Replace(twoClozes, "{{c1::eat}}", "{{c2::eat}} {{c1::rice}}", 1):  "",
+		strings.Replace(twoClozes, "{{c1::eat}}", "{{c1::eat}} {{c1::rice}}", 1):  "usage uses clozes c1, c2 but translation uses c1.",
+		strings.Replace(twoClozes, "{{c1::eat}}", "{{c3::eat}} {{c1::rice}}", 1):  "usage uses clozes c1, c2 but translation uses c1, c3.",
+		strings.Replace(twoClozes, "{{c1::eat}}", "{{c10::eat}} {{c1::rice}}", 1): "usage uses clozes c1, c2 but translation uses c1, c10.",
+	} {
+		entry, _ := ParseEntryBlock(input)
+		entry.validate([]Validator{ClozeNumbersValidator})
+		if got := strings.Join(entry.Warnings(), "\n"); got != expected || entry.IsDirty() {
+			t.Errorf("%q: got %q, expected %q", entry.Translation(), got, expected)
+		}
+	}
+}
+
+func TestSelfDefineValidator(t *testing.T) {
+	for input, expected := range map[string]int{
+		validEntry: 0,
+		strings.Replace(validEntry, "to eat", " 食べる ", 1):        1,
+		strings.Replace(validEntry, "to eat", "食べる (to eat)", 1): 0,
+	} {
+		entry, _ := ParseEntryBlock(input)
+		entry.validate([]Validator{SelfDefineValidator})
+		if len(entry.Warnings()) != expected || entry.IsDirty() {
+			t.Errorf("%q: expected %d warnings, got %v", entry.Definition(), expected, entry.Warnings())
+		}
+	}
+}
+
+func TestTagVocabularyValidator(t *testing.T) {
+	vocabulary, err := NewTagVocabularyFromFile(strings.NewReader("verb\nn5\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, test := range []struct {
+		tags     string
+		foldCase bool
+		expected string
+	}{
+		{"verb,n5", false, ""},
+		{"verb,noun,N5", false, "tags not in vocabulary: noun, N5."},
+		{"Verb,N5", true, ""},
+		{"Verb,noun", true, "tags not in vocabulary: noun."},
+	} {
+		entry, _ := ParseEntryBlock(strings.Replace(validEntry, "verb", test.tags, 1))
+		entry.validate([]Validator{TagVocabularyValidator(vocabulary, test.foldCase)})
+		if got := strings.Join(entry.Comments(), "\n"); got != test.expected || entry.IsDirty() != (test.expected != "") {
+			t.Errorf("%s: got %q, expected %q", test.tags, got, test.expected)
+		}
+	}
+}
+
+func TestWholeClozeValidator(t *testing.T) {
+	for translation, expected := range map[string]int{
+		"{{c1::eat}}":                  1,
+		" <i>{{c1::to eat rice}}</i> ": 1,
+		"I {{c1::eat}} rice":           0,
+		"{{c1::eat}} {{c2::rice}}":     0,
+	} {
+		entry, _ := ParseEntryBlock(strings.Replace(validEntry, "{{c1::eat}}", translation, 1))
+		entry.validate([]Validator{WholeClozeValidator})
+		if len(entry.Warnings()) != expected || entry.IsDirty() {
+			t.Errorf("%q: expected %d warnings, got %v", translation, expected, entry.Warnings())
+		}
+	}
+}
+
+func TestClozeAnswersValidator(t *testing.T) {
+	for usage, expected := range map[string]string{
+		"{{c1::食べる}}":                       "",
+		"{{c1::ご飯}}を{{c2::食べる}}":            "",
+		"{{c1::食べる}}と{{c2::食べる}}":           `usage: clozes c1 and c2 share the answer "食べる".`,
+		"{{c1::食べる::eat}}と{{c1::食べる::eat}}": `usage: clozes c1 and c1 share the answer "食べる".`,
+	} {
+		entry, _ := ParseEntryBlock(strings.Replace(validEntry, "{{c1::食べる}}", usage, 1))
+		entry.validate([]Validator{ClozeAnswersValidator})
+		if got := strings.Join(entry.Warnings(), "\n"); got != expected || entry.IsDirty() {
+			t.Errorf("%q: got %q, expected %q", usage, got, expected)
+		}
+	}
+}
+
+func TestSwappedValidator(t *testing.T) {
+	for _, test := range []struct {
+		usage, translation string
+		expected           int
+	}{
+		{"ご飯を{{c1::食べる}}", "I {{c1::eat}} rice", 0},
+		{"<b>{{c1::食べる}}</b>", "{{c1::eat}}", 0},
+		{"I {{c1::eat}} rice", "ご飯を{{c1::食べる}}", 1},
+		{"{{c1::eat}}", "{{c1::食べる}}", 1},
+	} {
+		input := strings.Replace(strings.Replace(validEntry, "{{c1::eat}}", test.translation, 1), "{{c1::食べる}}", test.usage, 1)
+		entry, _ := ParseEntryBlock(input)
+		entry.validate([]Validator{SwappedValidator})
+		if len(entry.Warnings()) != test.expected || entry.IsDirty() {
+			t.Errorf("%q, %q: expected %d warnings, got %v", test.usage, test.translation, test.expected, entry.Warnings())
+		}
+	}
+}
+
+func TestReadingValidator(t *testing.T) {
+	for _, test := range []struct {
+		word, pronunciation string
+		expected            int
+	}{
+		{"食べる", "たべる", 0},
+		{"食べる", "食べる", 1},
+		{" 食べる", "食べる ", 1},
+		{"たべる", "たべる", 0},
+		{"コーヒー", "コーヒー", 0},
+	} {
+		input := strings.Replace(strings.Replace(validEntry, "食べる\n", test.word+"\n", 1), "たべる", test.pronunciation, 1)
+		entry, _ := ParseEntryBlock(input)
+		entry.validate([]Validator{ReadingValidator})
+		if len(entry.Warnings()) != test.expected || entry.IsDirty() {
+			t.Errorf("%q, %q: expected %d warnings, got %v", test.word, test.pronunciation, test.expected, entry.Warnings())
+		}
+	}
+}
+
+func TestInvisibleValidator(t *testing.T) {
+	input := strings.Replace(validEntry, "to eat", "to\u200Beat", 1)
+	entry, errs := ParseEntryBlock(input)
+	if !entry.IsDirty() || len(errs) != 1 || errs[0].Error() != "definition contains invisible U+200B at character 3." {
+		t.Errorf("expected zero-width space in definition to be reported, got %v", errs)
+	}
+	entries, err := NewEntriesFromFileWithOptions(strings.NewReader(input), ParseOptions{StripInvisible: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entries[0].IsDirty() || entries[0].Definition() != "toeat" {
+		t.Errorf("zero-width space was not stripped: %q, %v", entries[0].Definition(), entries[0].Comments())
+	}
+}
+
+func TestNFCValidator(t *testing.T) {
+	// "が" decomposed into "か" and a combining voiced sound mark.
+	input := strings.Replace(validEntry, "to eat", "\u304B\u3099", 1)
+	entry, _ := ParseEntryBlock(input)
+	entry.validate([]Validator{NFCValidator})
+	if !entry.IsDirty() || strings.Join(entry.Comments(), "") != "definition is not in Unicode normalization form C." {
+		t.Errorf("expected decomposed definition to be reported, got %v", entry.Comments())
+	}
+	entries, err := NewEntriesFromFileWithOptions(strings.NewReader(input), ParseOptions{
+		Validators:   append(DefaultValidators(), NFCValidator),
+		NormalizeNFC: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entries[0].IsDirty() || entries[0].Definition() != "\u304C" {
+		t.Errorf("definition was not normalized: %q, %v", entries[0].Definition(), entries[0].Comments())
+	}
+}
+
+func TestMaxHTMLDepthValidator(t *testing.T) {
+	for input, expected := range map[string]int{
+		"to eat":                             0,
+		"<b><i>to</i></b> <b><i>eat</i></b>": 0,
+		"<div><p><span><b><i>to eat</i></b></span></p></div>":        0,
+		"<div><p><span><b><i><u>to eat</u></i></b></span></p></div>": 1,
+		"<div><p><span><b><i><u>to eat</i></b></span></p></div>":     0,
+	} {
+		entry, _ := ParseEntryBlock(strings.Replace(validEntry, "to eat", input, 1))
+		entry.validate([]Validator{MaxHTMLDepthValidator(5)})
+		if len(entry.Warnings()) != expected || entry.IsDirty() {
+			t.Errorf("%s: expected %d warnings, got %v", input, expected, entry.Warnings())
+		} else if expected != 0 {
+			t.Logf("%s: %v", input, entry.Warnings())
+		}
+	}
+}
+
+func TestTagOrderValidator(t *testing.T) {
+	for tags, expected := range map[string]int{
+		"verb":         0,
+		"n5,verb":      0,
+		"n5, verb,":    0,
+		"verb,n5":      1,
+		"n5,verb,food": 1,
+	} {
+		entry, _ := ParseEntryBlock(strings.Replace(validEntry, "verb", tags, 1))
+		entry.validate([]Validator{TagOrderValidator})
+		if len(entry.Warnings()) != expected || entry.IsDirty() {
+			t.Errorf("%s: expected %d warnings, got %v", tags, expected, entry.Warnings())
+		}
+	}
+}
+
+func TestPhraseValidator(t *testing.T) {
+	for word, expected := range map[string]int{
+		"食べる":   0,
+		" 食べる ": 0,
+		"<ruby class=\"w\">食<rt>た</rt></ruby>べる": 0,
+		"ご飯 を 食べる":                               1,
+		"ご飯\u3000食べる":                            1,
+	} {
+		entry, _ := ParseEntryBlock(strings.Replace(validEntry, "\n食べる\n", "\n"+word+"\n", 1))
+		entry.validate([]Validator{PhraseValidator})
+		if len(entry.Warnings()) != expected || entry.IsDirty() {
+			t.Errorf("%q: expected %d warnings, got %v", word, expected, entry.Warnings())
+		}
+	}
+}
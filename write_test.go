@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefaultTags(t *testing.T) {
+	entries, err := NewEntriesFromFile(strings.NewReader(strings.Replace(validEntry, "verb", "verb,n2", 1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, _, err := entries.Write(&buf, WriteOptions{Prefix: "TEST", DefaultTags: []string{"jlpt", "n2", "core"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, expected := buf.String(), "\tverb,n2,jlpt,core\n"; !strings.HasSuffix(got, expected) {
+		t.Errorf("got %q, expected suffix %q", got, expected)
+	}
+
+	// An entry without tags of its own gets only the defaults, with no
+	// leading comma from its empty tags line.
+	entries, err = NewEntriesFromFile(strings.NewReader(strings.Replace(validEntry, "verb", "", 1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buf.Reset()
+	if _, _, err := entries.Write(&buf, WriteOptions{Prefix: "TEST", DefaultTags: []string{"jlpt", "core"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, expected := buf.String(), "\tjlpt,core\n"; !strings.HasSuffix(got, expected) || strings.HasSuffix(got, ",jlpt,core\n") {
+		t.Errorf("got %q, expected suffix %q", got, expected)
+	}
+}
+
+func TestSince(t *testing.T) {
+	second := strings.Replace(validEntry, "0001", "0002", 1)
+	before, err := NewEntriesFromFile(strings.NewReader(validEntry + second))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var previous bytes.Buffer
+	if _, _, err := before.Write(&previous, WriteOptions{Prefix: "TEST"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	baseline, err := NewBaselineFromFile(&previous)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	after, err := NewEntriesFromFile(strings.NewReader(validEntry + strings.Replace(second, "to eat", "to consume", 1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	count, _, err := after.Write(&buf, WriteOptions{Prefix: "TEST", Baseline: baseline})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 1 || !strings.HasPrefix(buf.String(), "TEST-0002\t") || !strings.Contains(buf.String(), "to consume") {
+		t.Errorf("expected only the changed row, got %d rows: %q", count, buf.String())
+	}
+}
+
+func TestWriteGolden(t *testing.T) {
+	expected, err := os.ReadFile("testdata/entries.tsv")
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	for run := 0; run < 2; run++ {
+		f, err := os.Open("testdata/entries.txt")
+		if err != nil {
+			t.Fatalf("failed to open input file: %v", err)
+		}
+		entries, err := NewEntriesFromFile(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var buf bytes.Buffer
+		if _, _, err := entries.Write(&buf, WriteOptions{Prefix: "TEST"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(buf.Bytes(), expected) {
+			t.Errorf("run %d: output differs from golden file:\n%s", run, buf.String())
+		}
+	}
+}
+
+func TestWriteSourceRoundTrip(t *testing.T) {
+	input := strings.Replace(validEntry, "0001", "0001* recheck the translation", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "{{c1::eat}}", "eat", 1)
+	before, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := before.WriteSource(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != input {
+		t.Errorf("source changed:\n%s", buf.String())
+	}
+	after, err := NewEntriesFromFile(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for index := 0; index < 2; index++ {
+		if !before[index].Equal(after[index]) {
+			t.Errorf("%04d: entry changed: %+v != %+v", index+1, before[index], after[index])
+		}
+	}
+	if comments := after[0].Comments(); len(comments) != 1 || comments[0] != "recheck the translation" {
+		t.Errorf("unexpected comments: %q", comments)
+	}
+}
+
+func TestCommentPrefix(t *testing.T) {
+	input := strings.Replace(validEntry, "0001", "0001* // recheck the translation", 1) +
+		strings.Replace(validEntry, "0001", "0002  // see also 0001", 1) +
+		strings.Replace(validEntry, "0001", "0003", 1)
+	opts := ParseOptions{CommentPrefix: " // "}
+	entries, err := NewEntriesFromFileWithOptions(strings.NewReader(input), opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for index, expected := range []string{"recheck the translation", "see also 0001", ""} {
+		if got := strings.Join(entries[index].Comments(), ""); got != expected {
+			t.Errorf("%04d: got comment %q, expected %q", index+1, got, expected)
+		}
+	}
+	var buf bytes.Buffer
+	if err := entries.WriteSource(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != input {
+		t.Errorf("source changed:\n%s", buf.String())
+	}
+	after, err := NewEntriesFromFileWithOptions(&buf, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for index := 0; index < 3; index++ {
+		if !entries[index].Equal(after[index]) {
+			t.Errorf("%04d: entry changed: %+v != %+v", index+1, entries[index], after[index])
+		}
+	}
+	if _, err := NewEntriesFromFileWithOptions(strings.NewReader(strings.Replace(validEntry, "0001", "0001  recheck", 1)), opts); err == nil {
+		t.Errorf("comment without the prefix was accepted")
+	}
+}
+
+func TestRecordSeparator(t *testing.T) {
+	input := strings.Replace(validEntry, "---", "====", 1) +
+		strings.Replace(strings.Replace(validEntry, "0001", "0002", 1), "---", "====", 1)
+	entries, err := NewEntriesFromFileWithOptions(strings.NewReader(input), ParseOptions{RecordSeparator: "===="})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entries[1].ID() != 2 || entries[1].Tags()[0] != "verb" {
+		t.Errorf("unexpected entry: %+v", entries[1])
+	}
+	var buf bytes.Buffer
+	if err := entries.WriteSource(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != input {
+		t.Errorf("source changed:\n%s", buf.String())
+	}
+	if _, err := NewEntriesFromFileWithOptions(strings.NewReader(validEntry), ParseOptions{RecordSeparator: "===="}); err == nil {
+		t.Errorf("default delimiter was accepted with a custom record separator")
+	}
+	dir, path := writeInput(t, input)
+	if err := run([]string{"-record-sep", "", path, filepath.Join(dir, "output.csv")}, io.Discard, io.Discard); err == nil {
+		t.Errorf("empty record separator was accepted")
+	}
+}
+
+func TestExclude(t *testing.T) {
+	input := validEntry
+	for index, word := range []string{"飲む", "見る", "行く"} {
+		input += strings.Replace(strings.Replace(validEntry, "0001", fmt.Sprintf("%04d", index+2), 1), "食べる\n", word+"\n", 1)
+	}
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exclude, err := NewExcludeFromFile(strings.NewReader("見る\n\n 食べる \n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	count, _, err := entries.Write(&buf, WriteOptions{Prefix: "TEST", Exclude: exclude})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 2 || strings.Contains(buf.String(), "TEST-0001") || strings.Contains(buf.String(), "TEST-0003") {
+		t.Errorf("excluded entries were written:\n%s", buf.String())
+	}
+}
+
+func TestBOM(t *testing.T) {
+	entries, err := NewEntriesFromFile(strings.NewReader(validEntry))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, _, err := entries.Write(&buf, WriteOptions{Prefix: "TEST", BOM: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("\xef\xbb\xbfTEST-0001\t")) {
+		t.Errorf("byte order mark does not precede the first row: %q", buf.String())
+	}
+}
+
+func TestGUID(t *testing.T) {
+	var outputs [2]bytes.Buffer
+	for index := range outputs {
+		entries, err := NewEntriesFromFile(strings.NewReader(validEntry + strings.Replace(validEntry, "0001", "0002", 1)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, _, err := entries.Write(&outputs[index], WriteOptions{Prefix: "TEST", GUID: true}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if outputs[0].String() != outputs[1].String() {
+		t.Errorf("GUIDs differ between runs:\n%s\n%s", outputs[0].String(), outputs[1].String())
+	}
+	rows := strings.Split(outputs[0].String(), "\n")
+	first, second := strings.SplitN(rows[0], "\t", 2), strings.SplitN(rows[1], "\t", 2)
+	if first[0] == second[0] || !strings.HasPrefix(first[1], "TEST-0001\t") {
+		t.Errorf("unexpected GUID columns: %q, %q", first, second)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	input := ""
+	for id := 1; id <= 6; id++ {
+		entry := strings.Replace(validEntry, "0001", fmt.Sprintf("%04d", id), 1)
+		if id == 2 {
+			entry = strings.Replace(entry, "{{c1::eat}}", "eat", 1)
+		}
+		input += entry
+	}
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	count, dirty, err := entries.Write(&buf, WriteOptions{Prefix: "TEST", Limit: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var ids []string
+	for _, row := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		ids = append(ids, strings.SplitN(row, "\t", 2)[0])
+	}
+	if count != 3 || dirty != 1 || strings.Join(ids, ",") != "TEST-0001,TEST-0003,TEST-0004" {
+		t.Errorf("unexpected output: %d rows, %d dirty: %v", count, dirty, ids)
+	}
+}
+
+func TestShuffle(t *testing.T) {
+	input := ""
+	for id := 1; id <= 20; id++ {
+		input += strings.Replace(validEntry, "0001", fmt.Sprintf("%04d", id), 1)
+	}
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	order := func(seed int64) string {
+		var buf bytes.Buffer
+		if _, _, err := entries.Write(&buf, WriteOptions{Prefix: "TEST", Shuffle: true, Seed: seed}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var ids []string
+		for _, row := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			ids = append(ids, strings.SplitN(row, "\t", 2)[0])
+		}
+		return strings.Join(ids, ",")
+	}
+	first, second := order(42), order(42)
+	if first != second {
+		t.Errorf("same seed produced different orders:\n%s\n%s", first, second)
+	}
+	if strings.Count(first, "TEST-") != 20 {
+		t.Errorf("entries missing from shuffled output: %s", first)
+	}
+	if other := order(7); other == first {
+		t.Errorf("different seeds produced the same order: %s", other)
+	}
+}
+
+func TestNewlines(t *testing.T) {
+	entry, _ := ParseEntryBlock(validEntry)
+	entry.definition = "to eat\nto have a meal"
+	entries := Entries{entry}
+	for _, test := range []struct {
+		opts     WriteOptions
+		expected string
+	}{
+		{WriteOptions{}, "\"to eat\nto have a meal\"\t"},
+		{WriteOptions{CRLF: true}, "\"to eat\r\nto have a meal\"\t"},
+		{WriteOptions{EscapeNewlines: true}, "\tto eat<br>to have a meal\t"},
+		{WriteOptions{CRLF: true, EscapeNewlines: true}, "\tto eat<br>to have a meal\t"},
+	} {
+		var buf bytes.Buffer
+		if _, _, err := entries.Write(&buf, test.opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(buf.String(), test.expected) {
+			t.Errorf("%+v: expected %q in %q", test.opts, test.expected, buf.String())
+		}
+		if crlf := strings.HasSuffix(buf.String(), "\r\n"); crlf != test.opts.CRLF {
+			t.Errorf("%+v: unexpected row ending: %q", test.opts, buf.String())
+		}
+	}
+}
+
+func TestModified(t *testing.T) {
+	entries, err := NewEntriesFromFile(strings.NewReader(validEntry + strings.Replace(validEntry, "0001", "0002", 1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	warnings, err := entries.MergeModifiedFromFile(strings.NewReader("0001\t2024-03-01T09:30:00+09:00\n0003\t2024-03-02T00:00:00Z\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "unknown entry ID: 3") {
+		t.Errorf("expected a warning for the unknown ID, got %v", warnings)
+	}
+	var buf bytes.Buffer
+	if _, _, err := entries.Write(&buf, WriteOptions{Prefix: "TEST", Modified: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rows := strings.Split(buf.String(), "\n")
+	if !strings.HasSuffix(rows[0], "\tverb\t2024-03-01T09:30:00+09:00") || !strings.HasSuffix(rows[1], "\tverb\t") {
+		t.Errorf("unexpected rows: %q", rows)
+	}
+	if _, err := entries.MergeModifiedFromFile(strings.NewReader("0001\tyesterday\n")); err == nil {
+		t.Errorf("invalid timestamp was accepted")
+	}
+}
+
+func TestFrequencies(t *testing.T) {
+	input := ""
+	for index, word := range []string{"食べる", "飲む", "見る", "召し上がる"} {
+		input += strings.Replace(strings.Replace(validEntry, "0001", fmt.Sprintf("%04d", index+1), 1), "食べる\n", word+"\n", 1)
+	}
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	frequencies, err := NewFrequenciesFromFile(strings.NewReader("見る\t12\n食べる\t250\n飲む\t31\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, test := range []struct {
+		sort     string
+		expected string
+	}{
+		{SortID, "TEST-0001:250,TEST-0002:31,TEST-0003:12,TEST-0004:"},
+		{SortFrequency, "TEST-0003:12,TEST-0002:31,TEST-0001:250,TEST-0004:"},
+	} {
+		var buf bytes.Buffer
+		if _, _, err := entries.Write(&buf, WriteOptions{Prefix: "TEST", Frequencies: frequencies, Sort: test.sort}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var ranks []string
+		for _, row := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+			columns := strings.Split(row, "\t")
+			ranks = append(ranks, columns[0]+":"+columns[len(columns)-1])
+		}
+		if got := strings.Join(ranks, ","); got != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.sort, got, test.expected)
+		}
+	}
+	if _, err := NewFrequenciesFromFile(strings.NewReader("見る\tcommon\n")); err == nil {
+		t.Errorf("invalid rank was accepted")
+	}
+}
+
+func TestEmitLine(t *testing.T) {
+	input := "# drafted entries\n" + validEntry + strings.Replace(validEntry, "0001", "0002", 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entries[0].Line() != 2 || entries[1].Line() != 10 {
+		t.Errorf("unexpected lines: %d, %d", entries[0].Line(), entries[1].Line())
+	}
+	var buf bytes.Buffer
+	if _, _, err := entries.Write(&buf, WriteOptions{Prefix: "TEST", EmitLine: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rows := strings.Split(buf.String(), "\n")
+	if !strings.HasSuffix(rows[0], "\tverb\t2") || !strings.HasSuffix(rows[1], "\tverb\t10") {
+		t.Errorf("unexpected rows: %q", rows)
+	}
+}
+
+func TestEmptyPlaceholder(t *testing.T) {
+	entry, _ := ParseEntryBlock(strings.Replace(strings.Replace(validEntry, "たべる", "", 1), "verb", "", 1))
+	row := entry.CSV(WriteOptions{Prefix: "TEST", EmptyPlaceholder: "&nbsp;"})
+	if row[5] != "&nbsp;" {
+		t.Errorf("empty pronunciation was not replaced: %q", row)
+	}
+	if row[8] != "" {
+		t.Errorf("empty tags were replaced: %q", row)
+	}
+	if strings.Count(strings.Join(row, "\t"), "&nbsp;") != 1 {
+		t.Errorf("non-empty fields were replaced: %q", row)
+	}
+}
+
+func TestPlainFields(t *testing.T) {
+	input := strings.Replace(validEntry, "たべる", "た < べる", 1)
+	entries, err := NewEntriesFromFileWithOptions(strings.NewReader(input), ParseOptions{
+		Validators:  append(DefaultValidators(), ExtraHTMLValidator),
+		PlainFields: []string{"pronunciation"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entries[0].IsDirty() {
+		t.Errorf("plain field was validated as HTML: %v", entries[0].Comments())
+	}
+	if got, expected := entries[0].CSV(WriteOptions{Prefix: "TEST"})[5], "た &lt; べる"; got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+	if _, err := NewEntriesFromFileWithOptions(strings.NewReader(input), ParseOptions{PlainFields: []string{"nonexistent"}}); err == nil {
+		t.Errorf("unknown plain field was accepted")
+	}
+}
+
+func TestSplitClozes(t *testing.T) {
+	input := strings.Replace(strings.Replace(validEntry, "{{c1::eat}}", "{{c2::eat}} {{c1::rice::food}}", 1), "{{c1::食べる}}", "{{c1::ご飯}}を{{c2::食べる}}", 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	count, _, err := entries.Write(&buf, WriteOptions{Prefix: "TEST", SplitClozes: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rows := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if count != 1 || len(rows) != 2 {
+		t.Fatalf("expected 2 rows for 1 entry, got %d for %d: %q", len(rows), count, rows)
+	}
+	for index, expected := range []string{
+		"TEST-0001-c1\tご飯\t{{c1::ご飯}}を食べる\teat {{c1::rice::food}}\t",
+		"TEST-0001-c2\t食べる\tご飯を{{c1::食べる}}\t{{c1::eat}} rice\t",
+	} {
+		if !strings.HasPrefix(rows[index], expected) {
+			t.Errorf("row %d: got %q, expected prefix %q", index, rows[index], expected)
+		}
+	}
+}
+
+func TestIDBase(t *testing.T) {
+	input := validEntry + strings.Replace(validEntry, "0001", "0002", 1)
+	entries, err := NewEntriesFromFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entries[0].ID() != 1 || entries[1].ID() != 2 {
+		t.Errorf("source IDs were offset: %d, %d", entries[0].ID(), entries[1].ID())
+	}
+	var buf bytes.Buffer
+	if _, _, err := entries.Write(&buf, WriteOptions{Prefix: "TEST", IDBase: 3000}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rows := strings.Split(buf.String(), "\n")
+	for index, id := range []string{"TEST-3001", "TEST-3002"} {
+		if !strings.HasPrefix(rows[index], id+"\t") || !strings.Contains(rows[index], "[sound:"+id+".mp3]") {
+			t.Errorf("row %d: expected ID and audio %s, got %q", index, id, rows[index])
+		}
+	}
+	if _, err := NewEntriesFromFile(strings.NewReader(input + strings.Replace(validEntry, "0001", "3001", 1))); err == nil {
+		t.Errorf("ID 3001 was accepted as if offset")
+	}
+	if _, err := NewEntriesFromFile(strings.NewReader(input + validEntry)); err == nil {
+		t.Errorf("duplicate ID 0001 was not detected")
+	}
+}
+
+func TestSortTags(t *testing.T) {
+	entry, _ := ParseEntryBlock(strings.Replace(validEntry, "verb", "verb,n5", 1))
+	for _, test := range []struct {
+		opts     WriteOptions
+		expected string
+	}{
+		{WriteOptions{Prefix: "TEST"}, "verb,n5"},
+		{WriteOptions{Prefix: "TEST", SortTags: true}, "n5,verb"},
+		{WriteOptions{Prefix: "TEST", SortTags: true, DefaultTags: []string{"jlpt"}}, "jlpt,n5,verb"},
+	} {
+		if got := entry.CSV(test.opts)[8]; got != test.expected {
+			t.Errorf("%t, %v: got %q, expected %q", test.opts.SortTags, test.opts.DefaultTags, got, test.expected)
+		}
+	}
+}
+
+func TestAnkiDirectives(t *testing.T) {
+	entries, err := NewEntriesFromFile(strings.NewReader(validEntry))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	directives := &AnkiDirectives{Separator: "Tab", HTML: true, Notetype: "Cloze"}
+	for _, test := range []struct {
+		opts     WriteOptions
+		expected string
+	}{
+		{WriteOptions{Prefix: "TEST", Directives: directives}, "#separator:Tab\n#html:true\n#notetype:Cloze\n#tags column:9\nTEST-0001\t"},
+		{WriteOptions{Prefix: "TEST", Directives: directives, GUID: true}, "#separator:Tab\n#html:true\n#notetype:Cloze\n#guid column:1\n#tags column:10\n"},
+		{WriteOptions{Prefix: "TEST", Directives: directives, NoTagColumn: true}, "#separator:Tab\n#html:true\n#notetype:Cloze\nTEST-0001\t"},
+		{WriteOptions{Prefix: "TEST", Directives: &AnkiDirectives{Separator: "Tab", TagsColumn: 2}, Format: FormatBasic}, "#separator:Tab\n#html:false\n#tags column:2\nTEST-0001\t"},
+	} {
+		var buf bytes.Buffer
+		if _, _, err := entries.Write(&buf, test.opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.HasPrefix(buf.String(), test.expected) {
+			t.Errorf("got %q, expected it to start with %q", buf.String(), test.expected)
+		}
+		if baseline, err := NewBaselineFromFile(&buf); err != nil || len(baseline) != 1 {
+			t.Errorf("directives were read as baseline rows: %v, %v", baseline, err)
+		}
+	}
+	for _, opts := range []WriteOptions{
+		{Prefix: "#N2"},
+		{Prefix: "#N2", Directives: directives},
+	} {
+		var buf bytes.Buffer
+		if _, _, err := entries.Write(&buf, opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if baseline, err := NewBaselineFromFile(&buf); err != nil || len(baseline["#N2-0001"]) == 0 {
+			t.Errorf("%v: rows of a prefix starting with # were skipped: %v, %v", opts.Directives != nil, baseline, err)
+		}
+	}
+}